@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshot records size and modification time for every file below paths.
+// Paths that don't exist are skipped, so a watched directory can appear later.
+func snapshot(paths []string) map[string]fileState {
+	files := map[string]fileState{}
+	for _, root := range paths {
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			files[path] = fileState{size: fi.Size(), modTime: fi.ModTime()}
+			return nil
+		})
+	}
+	return files
+}
+
+func sameSnapshot(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, s := range a {
+		if t, ok := b[p]; !ok || t.size != s.size || !t.modTime.Equal(s.modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// watch polls paths and calls build whenever something under them
+// changes. A failed build is logged rather than fatal, so a bad edit
+// doesn't stop the watcher.
+func watch(paths []string, interval time.Duration, build func() error) {
+	rebuild := func() {
+		if err := build(); err != nil {
+			report(err)
+			log.Printf("build failed (waiting for changes)")
+		}
+	}
+
+	rebuild()
+	last := snapshot(paths)
+	log.Printf("Watching %v for changes", paths)
+	for range time.Tick(interval) {
+		cur := snapshot(paths)
+		if sameSnapshot(cur, last) {
+			continue
+		}
+		// Editors often write a file in several steps; wait for one quiet
+		// interval before rebuilding.
+		for {
+			time.Sleep(interval)
+			next := snapshot(paths)
+			if sameSnapshot(next, cur) {
+				break
+			}
+			cur = next
+		}
+		log.Println("Change detected, rebuilding")
+		rebuild()
+		last = snapshot(paths)
+	}
+}
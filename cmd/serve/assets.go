@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/genghisjahn/mywebsite/site"
+)
+
+// assetCache decides how long clients may cache each response, from the
+// asset manifest the build writes. Only the fingerprinted URLs it lists
+// are immutable, since any other URL can serve new content after the next
+// build. The manifest is read again whenever a build replaces it.
+type assetCache struct {
+	path string
+
+	mu        sync.RWMutex // guards the fields below
+	modTime   time.Time
+	manifest  *site.AssetManifest
+	immutable map[string]bool
+}
+
+func newAssetCache(path string) *assetCache {
+	a := &assetCache{path: path}
+	if err := a.reload(); err != nil {
+		log.Printf("Asset manifest: %v (nothing will be cached as immutable)", err)
+	}
+	return a
+}
+
+// reload reads the manifest again if it changed since it was last read.
+func (a *assetCache) reload() error {
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	a.mu.RLock()
+	same := fi.ModTime().Equal(a.modTime)
+	a.mu.RUnlock()
+	if same {
+		return nil
+	}
+	m, err := site.ReadAssetManifest(a.path)
+	if err != nil {
+		return err
+	}
+	immutable := make(map[string]bool, len(m.Immutable))
+	for _, u := range m.Immutable {
+		immutable[u] = true
+	}
+	a.mu.Lock()
+	a.modTime, a.manifest, a.immutable = fi.ModTime(), m, immutable
+	a.mu.Unlock()
+	return nil
+}
+
+// url returns the published URL for the asset at p, for templates the
+// server renders itself.
+func (a *assetCache) url(p string) string {
+	if err := a.reload(); err != nil && !os.IsNotExist(err) {
+		log.Printf("Asset manifest: %v", err)
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.manifest.URL(p)
+}
+
+// wrap sets Cache-Control on every response: a year for fingerprinted
+// assets, a few minutes for feeds so readers pick up new posts soon, and
+// revalidation on every request for everything else.
+func (a *assetCache) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.reload(); err != nil && !os.IsNotExist(err) {
+			log.Printf("Asset manifest: %v", err)
+		}
+		a.mu.RLock()
+		immutable := a.immutable[r.URL.Path]
+		a.mu.RUnlock()
+		switch {
+		case immutable:
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			w.Header().Set("Expires", time.Now().AddDate(1, 0, 0).UTC().Format(http.TimeFormat))
+		case isFeed(r.URL.Path):
+			w.Header().Set("Cache-Control", "public, max-age=300, must-revalidate")
+		default:
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isFeed(p string) bool {
+	switch path.Base(p) {
+	case "feed.xml", "atom.xml", "feed.json":
+		return true
+	}
+	return false
+}
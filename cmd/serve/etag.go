@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gzipETagSuffix marks the ETag of a gzipped response. The compressed and
+// identity bodies differ, so a strong ETag can't be shared between them.
+const gzipETagSuffix = "-gzip"
+
+// etagCache holds the content hash of each file served, so a file is
+// only read to hash it again once its size or modification time changes.
+type etagCache struct {
+	mu    sync.Mutex
+	files map[string]etagEntry
+}
+
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+func newETagCache() *etagCache {
+	return &etagCache{files: map[string]etagEntry{}}
+}
+
+// lookup returns the strong ETag of the file name, whose current state is
+// fi, hashing it if it's new or has changed.
+func (c *etagCache) lookup(name string, fi os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	e, ok := c.files[name]
+	c.mu.Unlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.etag, true
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", false
+	}
+	e = etagEntry{size: fi.Size(), modTime: fi.ModTime(), etag: `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`}
+	c.mu.Lock()
+	c.files[name] = e
+	c.mu.Unlock()
+	return e.etag, true
+}
+
+// gzipETag returns the ETag of the gzipped form of a response with etag.
+func gzipETag(etag string) string {
+	if strings.HasSuffix(etag, `"`) && !strings.HasSuffix(etag, gzipETagSuffix+`"`) {
+		return strings.TrimSuffix(etag, `"`) + gzipETagSuffix + `"`
+	}
+	return etag
+}
+
+// identityETags maps the gzip ETags in an If-None-Match header back to the
+// identity ETags the file server compares against.
+func identityETags(header string) string {
+	return strings.ReplaceAll(header, gzipETagSuffix+`"`, `"`)
+}
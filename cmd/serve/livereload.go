@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const reloadPath = "/_dev/reload"
+
+// reloadScript is injected before </body> of HTML pages in dev mode.
+const reloadScript = `<script>new EventSource("` + reloadPath + `").addEventListener("reload",function(){location.reload()});</script>`
+
+// reloader pushes a reload event over Server-Sent Events to every
+// connected page whenever the watched directories change.
+type reloader struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+func newReloader() *reloader {
+	return &reloader{clients: map[chan struct{}]struct{}{}}
+}
+
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The server's WriteTimeout would otherwise cut the stream off.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := make(chan struct{}, 1)
+	rl.mu.Lock()
+	rl.clients[ch] = struct{}{}
+	rl.mu.Unlock()
+	defer func() {
+		rl.mu.Lock()
+		delete(rl.clients, ch)
+		rl.mu.Unlock()
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	send := func(s string) bool {
+		if _, err := fmt.Fprint(w, s); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+	if !send(": connected\n\n") {
+		return
+	}
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if !send(": ping\n\n") {
+				return
+			}
+		case <-ch:
+			if !send("event: reload\ndata: {}\n\n") {
+				return
+			}
+		}
+	}
+}
+
+func (rl *reloader) notify() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for ch := range rl.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// poll notifies clients once the files below dirs change and then stay
+// unchanged for one interval, so a build in progress triggers one reload.
+func (rl *reloader) poll(dirs []string, interval time.Duration) {
+	last := dirSignature(dirs)
+	pending := last
+	for range time.Tick(interval) {
+		sig := dirSignature(dirs)
+		switch {
+		case sig != pending:
+			pending = sig
+		case sig != last:
+			last = sig
+			log.Println("Change detected, reloading pages")
+			rl.notify()
+		}
+	}
+}
+
+// dirSignature hashes the path, size and modification time of every file
+// below dirs.
+func dirSignature(dirs []string) uint64 {
+	h := fnv.New64a()
+	for _, root := range dirs {
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(h, "%s|%d|%d\n", path, fi.Size(), fi.ModTime().UnixNano())
+			return nil
+		})
+	}
+	return h.Sum64()
+}
+
+// reloadWrap injects the reload script into HTML responses. It must sit
+// inside gzipWrap so it sees the uncompressed body.
+func reloadWrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		body := rec.buf.Bytes()
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			if i := bytes.LastIndex(body, []byte("</body>")); i >= 0 {
+				body = append(body[:i:i], append([]byte(reloadScript), body[i:]...)...)
+			}
+			if w.Header().Get("Content-Length") != "" {
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+		}
+		w.WriteHeader(rec.status)
+		w.Write(body)
+	})
+}
+
+// bufferedResponseWriter holds the status and body back so a wrapper can
+// rewrite them before anything reaches the client.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) { b.status = status }
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) { return b.buf.Write(p) }
+
+// noStoreWrap replaces assetCache.wrap in dev mode so reloads always
+// fetch fresh CSS and images.
+func noStoreWrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -14,6 +14,8 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/genghisjahn/mywebsite/site"
 )
 
 func init() {
@@ -23,30 +25,98 @@ func init() {
 func main() {
 	addr := flag.String("addr", ":8080", "listen address")
 	publicDir := flag.String("public", "./public", "public dir")
-	cssDir := flag.String("css", "./css", "css dir")
-	imagesDir := flag.String("images", "./images", "images dir")
+	serverDir := flag.String("server", "", "files the build writes for the server: the asset manifest, redirect rules, site.env and templates (default <public>.server)")
+	cssDir := flag.String("css", "", "css dir (default <public>/css, where the build publishes fingerprinted stylesheets)")
+	imagesDir := flag.String("images", "", "images dir (default <public>/images, where the build publishes converted images)")
+	dev := flag.Bool("dev", false, "disable caching and live-reload pages when the served files change")
+	searchIndexPath := flag.String("index", "", "search index written by the build (default <public>/search/index.json)")
+	templatesDir := flag.String("templates", "", "templates dir, for rendering search results (default <server>/templates)")
+	sitePath := flag.String("site", "", "site config, for rendering search results (default <server>/site.env)")
+	redirectsPath := flag.String("redirects", "", "redirect rules written by the build, reread when they change or on SIGHUP (default <server>/redirects)")
+	soft404 := flag.Bool("soft404", false, "serve the 404 page with status 200, for proxies that replace 404 responses")
 	flag.Parse()
+	if *cssDir == "" {
+		*cssDir = filepath.Join(*publicDir, "css")
+	}
+	if *imagesDir == "" {
+		*imagesDir = filepath.Join(*publicDir, "images")
+	}
+	if *serverDir == "" {
+		*serverDir = site.DefaultServerDir(*publicDir)
+	}
+	if *redirectsPath == "" {
+		*redirectsPath = filepath.Join(*serverDir, site.RedirectsFile)
+	}
+	if *templatesDir == "" {
+		*templatesDir = filepath.Join(*serverDir, site.ServerTemplatesDir)
+	}
+	if *sitePath == "" {
+		*sitePath = filepath.Join(*serverDir, site.ServerConfigFile)
+	}
 
 	mux := http.NewServeMux()
 
+	assets := newAssetCache(filepath.Join(*serverDir, site.AssetManifestFile))
+	cache := assets.wrap
+	pages := custom404Handler(*publicDir, *soft404)
+	if *dev {
+		cache = noStoreWrap
+		pages = reloadWrap(pages)
+
+		rl := newReloader()
+		go rl.poll([]string{*publicDir, *cssDir, *imagesDir}, 500*time.Millisecond)
+		mux.Handle(reloadPath, rl)
+	}
+
+	// /search?q= -> ranked results from the build's search index
+	if *searchIndexPath == "" {
+		*searchIndexPath = filepath.Join(*publicDir, "search", "index.json")
+	}
+	if idx, err := newSearchIndexFile(*searchIndexPath); err != nil {
+		log.Printf("Search disabled: %v", err)
+	} else {
+		sh := &searchHandler{idx: idx}
+		if err := sh.loadTemplate(*sitePath, *templatesDir, assets.url); err != nil {
+			log.Printf("Search results as HTML disabled, falling back to /search/: %v", err)
+		}
+		mux.Handle("/search", gzipWrap(logWrap(cache(sh))))
+		log.Printf("Search index %s (%d documents, reread when it changes)", abs(*searchIndexPath), len(idx.index().Docs))
+	}
+
 	// / -> public (with custom 404 handling)
-	mux.Handle("/", gzipWrap(logWrap(cacheWrap(custom404Handler(*publicDir)))))
+	if *dev {
+		// Dev mode rewrites pages on the way out, so they're always
+		// compressed on the fly.
+		mux.Handle("/", gzipWrap(logWrap(cache(pages))))
+	} else {
+		mux.Handle("/", logWrap(cache(newStaticDir(*publicDir).wrap(gzipWrap(pages)))))
+	}
 
 	// /css -> css
 	mux.Handle("/css/",
-		gzipWrap(logWrap(cacheWrap(http.StripPrefix("/css/",
-			http.FileServer(http.Dir(*cssDir)))))))
+		logWrap(cache(http.StripPrefix("/css/",
+			newStaticDir(*cssDir).wrap(gzipWrap(http.FileServer(http.Dir(*cssDir))))))))
 
 	// /images -> images (if present)
 	if dirExists(*imagesDir) {
 		mux.Handle("/images/",
-			logWrap(cacheWrap(http.StripPrefix("/images/",
-				http.FileServer(http.Dir(*imagesDir))))))
+			logWrap(cache(http.StripPrefix("/images/",
+				newStaticDir(*imagesDir).wrap(gzipWrap(http.FileServer(http.Dir(*imagesDir))))))))
 	}
 
+	rd := newRedirector(*redirectsPath, *publicDir)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("SIGHUP: reloading redirects")
+			rd.reload(true)
+		}
+	}()
+
 	srv := &http.Server{
 		Addr:         *addr,
-		Handler:      mux,
+		Handler:      rd.wrap(mux),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -57,6 +127,9 @@ func main() {
 	if dirExists(*imagesDir) {
 		log.Printf("Mount /images -> %s", abs(*imagesDir))
 	}
+	if *dev {
+		log.Printf("Dev mode: live reload on %s", reloadPath)
+	}
 
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -74,18 +147,6 @@ func main() {
 	log.Println("Server stopped")
 }
 
-func cacheWrap(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if hasExt(r.URL.Path, ".css", ".js", ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".ico", ".woff", ".woff2", ".xml", ".webmanifest") {
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			w.Header().Set("Expires", time.Now().AddDate(1, 0, 0).UTC().Format(http.TimeFormat))
-		} else {
-			w.Header().Set("Cache-Control", "no-cache")
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func logWrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -116,83 +177,161 @@ func abs(p string) string {
 	return a
 }
 
-// gzip compression middleware
+// gzipWrap compresses responses for clients that accept gzip. Whether a
+// response is compressible is decided from its Content-Type once the
+// handler sends its headers, so directory URLs, the 404 page and search
+// results are compressed like any .html file.
 func gzipWrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip if client doesn't accept gzip
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, r)
-			return
+		gw := &gzipResponseWriter{ResponseWriter: w, inm: r.Header.Get("If-None-Match")}
+		gw.accept = preferredEncoding(r.Header.Get("Accept-Encoding"), "gzip") != ""
+		if gw.accept && gw.inm != "" {
+			// The handlers below see the identity ETag, so match against it.
+			r = r.Clone(r.Context())
+			r.Header.Set("If-None-Match", identityETags(gw.inm))
 		}
-		// Only compress compressible types
-		if !isCompressible(r.URL.Path) {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		w.Header().Set("Content-Encoding", "gzip")
-		w.Header().Del("Content-Length") // Length changes after compression
-
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
-
-		next.ServeHTTP(&gzipResponseWriter{ResponseWriter: w, Writer: gz}, r)
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
 	})
 }
 
+// gzipResponseWriter compresses the body written through it if the
+// response is compressible and the client accepts gzip. The gzip stream
+// is only started once there's a body, so HEAD responses go out empty.
+// Partial content and redirects are passed through as they are.
 type gzipResponseWriter struct {
 	http.ResponseWriter
-	io.Writer
+	accept      bool   // the client accepts gzip
+	inm         string // the request's If-None-Match, before identityETags
+	gz          *gzip.Writer
+	compress    bool
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	etag := h.Get("ETag")
+	switch {
+	case h.Get("Content-Encoding") != "":
+	case status == http.StatusNotModified:
+		// A 304 carries no Content-Type, but if the client validated the
+		// gzipped body, it's answered with that body's ETag.
+		if g.accept && etag != "" && strings.Contains(g.inm, gzipETag(etag)) {
+			varyAcceptEncoding(h)
+			h.Set("ETag", gzipETag(etag))
+		}
+	case compressibleType(h.Get("Content-Type")):
+		varyAcceptEncoding(h)
+		if g.accept && (status == http.StatusOK || status >= http.StatusBadRequest) {
+			g.compress = true
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length") // Length changes after compression
+			if etag != "" {
+				h.Set("ETag", gzipETag(etag))
+			}
+		}
+	}
+	g.ResponseWriter.WriteHeader(status)
 }
 
 func (g *gzipResponseWriter) Write(b []byte) (int, error) {
-	return g.Writer.Write(b)
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.compress {
+		return g.ResponseWriter.Write(b)
+	}
+	if g.gz == nil {
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	return g.gz.Write(b)
+}
+
+func (g *gzipResponseWriter) Close() error {
+	if g.gz == nil {
+		return nil
+	}
+	return g.gz.Close()
 }
 
+// isCompressible reports whether a file is worth compressing, by name.
 func isCompressible(path string) bool {
 	return hasExt(path, ".html", ".css", ".js", ".json", ".xml", ".svg", ".txt", ".webmanifest")
 }
 
-// custom404Handler serves files from dir, falling back to 404.html for missing files
-func custom404Handler(dir string) http.Handler {
+// compressibleType is isCompressible for a response's Content-Type: text,
+// and the JSON, XML and JavaScript formats, SVG among them.
+func compressibleType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mt {
+	case "application/javascript", "application/json", "application/xml":
+		return true
+	}
+	return strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "+xml") || strings.HasSuffix(mt, "+json")
+}
+
+// custom404Handler serves files from dir, falling back to 404.html for
+// missing files and for directories without an index.html. The fallback
+// is sent with status 404 unless soft is set, and is never indexed.
+func custom404Handler(dir string, soft bool) http.Handler {
 	fs := http.Dir(dir)
 	fileServer := http.FileServer(fs)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
 
-		// Try to open the requested file
-		f, err := fs.Open(path)
-		if err == nil {
-			f.Close()
-			fileServer.ServeHTTP(w, r)
-			return
-		}
-
-		// Try with index.html for directories
-		if !strings.HasSuffix(path, "/") {
-			path = path + "/"
-		}
-		f, err = fs.Open(path + "index.html")
-		if err == nil {
+		// Try to open the requested file, or the index.html of a directory
+		if f, err := fs.Open(path); err == nil {
+			fi, err := f.Stat()
 			f.Close()
-			fileServer.ServeHTTP(w, r)
-			return
+			if err == nil && !fi.IsDir() {
+				fileServer.ServeHTTP(w, r)
+				return
+			}
+			if err == nil {
+				if index, err := fs.Open(strings.TrimSuffix(path, "/") + "/index.html"); err == nil {
+					index.Close()
+					fileServer.ServeHTTP(w, r)
+					return
+				}
+			}
 		}
 
-		// Serve custom 404 page with 200 status to bypass Cloudflare interception
-		notFoundFile, openErr := fs.Open("/404.html")
-		if openErr != nil {
-			http.NotFound(w, r)
-			return
+		status := http.StatusNotFound
+		if soft {
+			// Some proxies, such as Cloudflare, swap a 404 for their own page.
+			status = http.StatusOK
 		}
-		defer notFoundFile.Close()
-		content, readErr := io.ReadAll(notFoundFile)
-		if readErr != nil {
-			http.NotFound(w, r)
-			return
-		}
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Write(content)
+		serveFallback(w, fs, status)
 	})
 }
+
+// serveFallback sends dir's 404.html with status, marked so search engines
+// don't index it.
+func serveFallback(w http.ResponseWriter, dir http.FileSystem, status int) {
+	w.Header().Set("X-Robots-Tag", "noindex")
+	notFoundFile, openErr := dir.Open("/404.html")
+	if openErr != nil {
+		http.Error(w, "page not found", status)
+		return
+	}
+	defer notFoundFile.Close()
+	content, readErr := io.ReadAll(notFoundFile)
+	if readErr != nil {
+		http.Error(w, "page not found", status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(content)
+}
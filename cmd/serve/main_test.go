@@ -0,0 +1,115 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCustom404Handler(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"404.html":         "not here",
+		"about.txt":        "about",
+		"with/index.html":  "index",
+		"without/note.txt": "note",
+	})
+
+	tests := []struct {
+		target  string
+		soft    bool
+		status  int
+		noindex bool
+		body    string
+	}{
+		{"/about.txt", false, http.StatusOK, false, "about"},
+		{"/with/", false, http.StatusOK, false, "index"},
+		{"/without/", false, http.StatusNotFound, true, "not here"},
+		{"/missing", false, http.StatusNotFound, true, "not here"},
+		{"/about.txt", true, http.StatusOK, false, "about"},
+		{"/with/", true, http.StatusOK, false, "index"},
+		{"/without/", true, http.StatusOK, true, "not here"},
+		{"/missing", true, http.StatusOK, true, "not here"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		custom404Handler(dir, tt.soft).ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		noindex := w.Header().Get("X-Robots-Tag") == "noindex"
+		if w.Code != tt.status || noindex != tt.noindex || w.Body.String() != tt.body {
+			t.Errorf("GET %s (soft %v) = %d, noindex %v, body %q; want %d, %v, %q",
+				tt.target, tt.soft, w.Code, noindex, w.Body.String(), tt.status, tt.noindex, tt.body)
+		}
+	}
+}
+
+func TestServeFallbackWithout404Page(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusOK, http.StatusGone} {
+		w := httptest.NewRecorder()
+		serveFallback(w, http.Dir(t.TempDir()), status)
+		if w.Code != status || w.Header().Get("X-Robots-Tag") != "noindex" || !strings.Contains(w.Body.String(), "page not found") {
+			t.Errorf("serveFallback(%d) = %d, X-Robots-Tag %q, body %q", status, w.Code, w.Header().Get("X-Robots-Tag"), w.Body.String())
+		}
+	}
+}
+
+func TestGzipWrap(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"404.html":              "<!doctype html><p>not here</p>",
+		"index.html":            "<!doctype html><p>home</p>",
+		"articles/x/index.html": "<!doctype html><p>article</p>",
+		"feed.xml":              "<rss></rss>",
+		"photo.png":             "\x89PNG\r\n\x1a\n not really",
+	})
+	h := gzipWrap(custom404Handler(dir, false))
+
+	tests := []struct {
+		target   string
+		encoding string // Accept-Encoding
+		status   int
+		gzipped  bool
+		vary     bool
+		body     string
+	}{
+		{"/", "gzip", http.StatusOK, true, true, "<!doctype html><p>home</p>"},
+		{"/articles/x/", "br;q=1, gzip;q=0.5", http.StatusOK, true, true, "<!doctype html><p>article</p>"},
+		{"/feed.xml", "gzip", http.StatusOK, true, true, "<rss></rss>"},
+		{"/missing", "gzip", http.StatusNotFound, true, true, "<!doctype html><p>not here</p>"},
+		{"/", "", http.StatusOK, false, true, "<!doctype html><p>home</p>"},
+		{"/", "gzip;q=0", http.StatusOK, false, true, "<!doctype html><p>home</p>"},
+		{"/photo.png", "gzip", http.StatusOK, false, false, "\x89PNG\r\n\x1a\n not really"},
+		{"/articles/x", "gzip", http.StatusMovedPermanently, false, false, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		if tt.encoding != "" {
+			req.Header.Set("Accept-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		vary := w.Header().Get("Vary") == "Accept-Encoding"
+		if w.Code != tt.status || gzipped != tt.gzipped || vary != tt.vary {
+			t.Errorf("GET %s (Accept-Encoding %q) = %d, gzipped %v, Vary %q; want %d, %v, vary %v",
+				tt.target, tt.encoding, w.Code, gzipped, w.Header().Get("Vary"), tt.status, tt.gzipped, tt.vary)
+			continue
+		}
+		if tt.body == "" {
+			continue
+		}
+		var body io.Reader = w.Body
+		if gzipped {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.target, err)
+			}
+			body = zr
+		}
+		if b, err := io.ReadAll(body); err != nil || string(b) != tt.body {
+			t.Errorf("GET %s (Accept-Encoding %q) body = %q, %v; want %q", tt.target, tt.encoding, b, err, tt.body)
+		}
+	}
+}
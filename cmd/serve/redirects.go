@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/genghisjahn/mywebsite/site"
+)
+
+// redirector answers requests that match the rules in the build's
+// redirects file before anything else sees them. The rules are read again
+// whenever a build replaces the file, the way assetCache rereads the asset
+// manifest, and on SIGHUP.
+type redirector struct {
+	path   string
+	public http.FileSystem // for the page sent with a 410
+
+	mu      sync.RWMutex // guards the fields below
+	modTime time.Time
+	rules   site.Redirects
+}
+
+func newRedirector(path, publicDir string) *redirector {
+	rd := &redirector{path: path, public: http.Dir(publicDir)}
+	rd.reload(true)
+	return rd
+}
+
+// reload reads the rules again if the file changed since they were last
+// read, or regardless if force is set. A missing file means no rules; one
+// that can't be read or has errors leaves the current rules in place.
+func (rd *redirector) reload(force bool) {
+	var modTime time.Time
+	fi, err := os.Stat(rd.path)
+	switch {
+	case err == nil:
+		modTime = fi.ModTime()
+	case !os.IsNotExist(err):
+		log.Printf("Redirects: %v (keeping the current rules)", err)
+		return
+	}
+	rd.mu.RLock()
+	same := modTime.Equal(rd.modTime)
+	rd.mu.RUnlock()
+	if same && !force {
+		return
+	}
+
+	b, err := os.ReadFile(rd.path)
+	if os.IsNotExist(err) {
+		log.Printf("Redirects: no %s", abs(rd.path))
+		b, err = nil, nil
+	}
+	var rules site.Redirects
+	if err == nil {
+		rules, err = site.ParseRedirects(rd.path, b)
+	}
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.modTime = modTime
+	if err != nil {
+		log.Printf("Redirects: %v (keeping the current rules)", err)
+		return
+	}
+	rd.rules = rules
+	if len(rules) > 0 {
+		log.Printf("Redirects %s (%d rules)", abs(rd.path), len(rules))
+	}
+}
+
+func (rd *redirector) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rd.reload(false)
+		rd.mu.RLock()
+		to, status, ok := rd.rules.Match(r.URL.Path)
+		rd.mu.RUnlock()
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		log.Printf("%s %s -> %d %s", r.Method, r.URL.Path, status, to)
+		if status == http.StatusGone {
+			serveFallback(w, rd.public, status)
+			return
+		}
+		if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, status)
+	})
+}
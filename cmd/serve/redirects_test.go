@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRedirectorLocation(t *testing.T) {
+	dir := t.TempDir()
+	rulesPath := filepath.Join(dir, "redirects")
+	if err := os.WriteFile(rulesPath, []byte("/blog/* /:splat\n/old /new/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rd := newRedirector(rulesPath, dir)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	h := rd.wrap(next)
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"/blog/x/", http.StatusMovedPermanently, "/x/"},
+		{"/blog//evil.com", http.StatusMovedPermanently, "/evil.com"},
+		{"/blog/%2F%2Fevil.com", http.StatusMovedPermanently, "/evil.com"},
+		{"/old?a=1", http.StatusMovedPermanently, "/new/?a=1"},
+		{"/other", http.StatusTeapot, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s = %d, Location %q; want %d, %q", tt.target, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
+
+func TestRedirectorReloadsChangedRules(t *testing.T) {
+	rulesPath := filepath.Join(t.TempDir(), "redirects")
+	write := func(body string, mod time.Time) {
+		t.Helper()
+		if err := os.WriteFile(rulesPath, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(rulesPath, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write("/a /x/\n", start)
+	rd := newRedirector(rulesPath, t.TempDir())
+	h := rd.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }))
+	get := func(target string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w.Header().Get("Location")
+	}
+
+	if got := get("/a"); got != "/x/" {
+		t.Fatalf("GET /a redirects to %q, want /x/", got)
+	}
+	// A deploy adds an alias.
+	write("/a /x/\n/b /y/\n", start.Add(time.Minute))
+	if got := get("/b"); got != "/y/" {
+		t.Errorf("after a rebuild GET /b redirects to %q, want /y/", got)
+	}
+	// A broken file keeps the last good rules.
+	write("/c\n", start.Add(2*time.Minute))
+	if got := get("/b"); got != "/y/" {
+		t.Errorf("after a bad write GET /b redirects to %q, want /y/", got)
+	}
+	if err := os.Remove(rulesPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("/a"); got != "" {
+		t.Errorf("after removal GET /a redirects to %q, want nothing", got)
+	}
+}
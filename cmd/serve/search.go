@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/genghisjahn/mywebsite/site"
+)
+
+// searchIndex is the index cmd/build writes to public/search/index.json.
+type searchIndex struct {
+	site.SearchIndex
+	avgLen float64
+	titles []tokenSet // tokens of each document's title, by document
+	tags   []tokenSet // tokens of each document's tags, by document
+}
+
+// tokenSet is the set of terms site.Tokenize finds in a string, so the
+// title and tag boosts don't tokenize every document on every query.
+type tokenSet map[string]bool
+
+func newTokenSet(s string) tokenSet {
+	set := tokenSet{}
+	for _, t := range site.Tokenize(s) {
+		set[t] = true
+	}
+	return set
+}
+
+// searchHit is one result as the JSON response gives it. The document
+// length only matters for ranking, so it's left out.
+type searchHit struct {
+	site.SearchResult
+	Score float64 `json:"score"`
+}
+
+// BM25 parameters and the extra weight given to a query term found in a
+// document's title or tags. These match the client-side search page.
+const (
+	bm25K1     = 1.2
+	bm25B      = 0.75
+	titleBoost = 3.0
+	tagBoost   = 2.0
+)
+
+func loadSearchIndex(path string) (*searchIndex, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var idx searchIndex
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, d := range idx.Docs {
+		total += d.Len
+		idx.titles = append(idx.titles, newTokenSet(d.Title))
+		idx.tags = append(idx.tags, newTokenSet(strings.Join(d.Tags, " ")))
+	}
+	if len(idx.Docs) > 0 {
+		idx.avgLen = float64(total) / float64(len(idx.Docs))
+	}
+	return &idx, nil
+}
+
+// searchIndexFile is the search index at path. It's read again whenever
+// a build replaces it, the way assetCache rereads the asset manifest.
+type searchIndexFile struct {
+	path string
+
+	mu      sync.RWMutex // guards the fields below
+	modTime time.Time
+	idx     *searchIndex
+}
+
+func newSearchIndexFile(path string) (*searchIndexFile, error) {
+	f := &searchIndexFile{path: path}
+	return f, f.reload()
+}
+
+// reload reads the index again if it changed since it was last read.
+func (f *searchIndexFile) reload() error {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.RLock()
+	same := fi.ModTime().Equal(f.modTime)
+	f.mu.RUnlock()
+	if same {
+		return nil
+	}
+	idx, err := loadSearchIndex(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.modTime, f.idx = fi.ModTime(), idx
+	f.mu.Unlock()
+	return nil
+}
+
+// index returns the current index, keeping the last one read if the file
+// can't be read now.
+func (f *searchIndexFile) index() *searchIndex {
+	if err := f.reload(); err != nil {
+		log.Printf("Search index: %v (keeping the last one read)", err)
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.idx
+}
+
+// search ranks documents for q by BM25 over the body, boosted for terms
+// that appear in the title or tags. Ties go to the newer document.
+func (idx *searchIndex) search(q string) []searchHit {
+	n := float64(len(idx.Docs))
+	scores := map[int]float64{}
+	seen := map[string]bool{}
+	for _, term := range site.Tokenize(q) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := idx.Terms[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			if len(p) != 2 || p[0] < 0 || p[0] >= len(idx.Docs) {
+				continue
+			}
+			d, tf := idx.Docs[p[0]], float64(p[1])
+			norm := 1 - bm25B + bm25B*float64(d.Len)/math.Max(idx.avgLen, 1)
+			scores[p[0]] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+		for i := range idx.Docs {
+			if idx.titles[i][term] {
+				scores[i] += titleBoost * idf
+			}
+			if idx.tags[i][term] {
+				scores[i] += tagBoost * idf
+			}
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for i, s := range scores {
+		hits = append(hits, searchHit{SearchResult: idx.Docs[i].SearchResult, Score: s})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Date > hits[j].Date
+	})
+	return hits
+}
+
+// loadTemplate loads what rendering results as HTML needs: site.env and
+// the list template with its partials. asset maps image and stylesheet
+// URLs to the ones the build published.
+func (h *searchHandler) loadTemplate(sitePath, templatesDir string, asset func(string) string) error {
+	cfg, err := site.LoadConfig(sitePath)
+	if err != nil {
+		return err
+	}
+	tpl, err := site.ParseTemplate(os.DirFS(templatesDir), "list.html.tmpl")
+	if err != nil {
+		return err
+	}
+	tpl.Funcs(template.FuncMap{"image": asset, "asset": asset})
+	h.site, h.tpl = cfg, tpl
+	return nil
+}
+
+// searchHandler serves /search?q=. Results are JSON when the client asks
+// for it; otherwise they are rendered through the site's list template,
+// or, without one, handed to the static search page.
+type searchHandler struct {
+	idx  *searchIndexFile
+	tpl  *template.Template
+	site site.Config
+}
+
+const maxSearchResults = 50
+
+func (h *searchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	hits := h.idx.index().search(q)
+	if len(hits) > maxSearchResults {
+		hits = hits[:maxSearchResults]
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Vary", "Accept")
+		json.NewEncoder(w).Encode(struct {
+			Query   string      `json:"query"`
+			Results []searchHit `json:"results"`
+		}{q, hits})
+		return
+	}
+	if h.tpl == nil {
+		http.Redirect(w, r, "/search/?q="+url.QueryEscape(q), http.StatusFound)
+		return
+	}
+
+	lv := site.ListView{Site: h.site, Title: "Search"}
+	if q != "" {
+		lv.Title = fmt.Sprintf("Search: %s (%d)", q, len(hits))
+	}
+	for _, hit := range hits {
+		lv.Items = append(lv.Items, site.ListItem{
+			Title:     hit.Title,
+			URL:       hit.URL,
+			ISODate:   hit.Date,
+			HumanDate: humanDate(hit.Date),
+			Type:      hit.Type,
+		})
+	}
+	buf := new(bytes.Buffer)
+	if err := h.tpl.Execute(buf, lv); err != nil {
+		log.Printf("render search results: %v", err)
+		http.Error(w, "search failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Vary", "Accept")
+	w.Write(buf.Bytes())
+}
+
+func humanDate(s string) string {
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("January 2, 2006")
+		}
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadTestIndex(t *testing.T) *searchIndex {
+	t.Helper()
+	idx, err := loadSearchIndex(filepath.Join("testdata", "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := loadTestIndex(t)
+	tests := []struct {
+		q    string
+		want []string // titles, best first
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"the and of", nil},
+		{"x", nil},
+		{"zebra", nil},
+		{"handling", []string{"Go Error Handling"}},
+		// Title and tag boosts put the short note well ahead.
+		{"go", []string{"Go Error Handling", "Baking Bread"}},
+		// Length normalization: two mentions in 10 terms beat three in 20.
+		{"error", []string{"Go Error Handling", "Error Budgets"}},
+		{"bread", []string{"Baking Bread", "Sourdough Starter"}},
+		{"go error", []string{"Go Error Handling", "Error Budgets", "Baking Bread"}},
+		{"Go, ERROR!", []string{"Go Error Handling", "Error Budgets", "Baking Bread"}},
+		// Equal scores go to the newer document.
+		{"post", []string{"New Post", "Old Post"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, h := range idx.search(tt.q) {
+			got = append(got, h.Title)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("search(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+// score is the score search gives the document titled title for q.
+func score(t *testing.T, idx *searchIndex, q, title string) float64 {
+	t.Helper()
+	for _, h := range idx.search(q) {
+		if h.Title == title {
+			return h.Score
+		}
+	}
+	t.Fatalf("search(%q) doesn't find %q", q, title)
+	return 0
+}
+
+func TestSearchScores(t *testing.T) {
+	idx := loadTestIndex(t)
+	n := float64(len(idx.Docs))
+	idf := func(df float64) float64 { return math.Log(1 + (n-df+0.5)/(df+0.5)) }
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	// BM25 for one mention in a 10-term body, average length 23, plus
+	// the title boost.
+	norm := 1 - bm25B + bm25B*10/23
+	want := idf(1)*(bm25K1+1)/(1+bm25K1*norm) + titleBoost*idf(1)
+	if got := score(t, idx, "handling", "Go Error Handling"); !near(got, want) {
+		t.Errorf("score for handling = %v, want %v", got, want)
+	}
+
+	// The two kernel documents differ only in the title.
+	if d := score(t, idx, "kernel", "Kernel Notes") - score(t, idx, "kernel", "Systems Notes"); !near(d, titleBoost*idf(2)) {
+		t.Errorf("title boost = %v, want %v", d, titleBoost*idf(2))
+	}
+	// The two rust documents differ only in the tags.
+	if d := score(t, idx, "rust", "Memory Safety") - score(t, idx, "rust", "Memory Models"); !near(d, tagBoost*idf(2)) {
+		t.Errorf("tag boost = %v, want %v", d, tagBoost*idf(2))
+	}
+	// A term the index doesn't have still counts in titles.
+	if got := score(t, idx, "post", "Old Post"); !near(got, titleBoost*idf(0)) {
+		t.Errorf("score for post = %v, want %v", got, titleBoost*idf(0))
+	}
+}
+
+func TestSearchDedupesQueryTerms(t *testing.T) {
+	idx := loadTestIndex(t)
+	tests := []struct{ q, once string }{
+		{"go go", "go"},
+		{"go Go GO", "go"},
+		{"go, go error error", "go error"},
+		{"error go error", "error go"},
+	}
+	for _, tt := range tests {
+		got, want := idx.search(tt.q), idx.search(tt.once)
+		if len(got) != len(want) {
+			t.Errorf("search(%q) has %d hits, search(%q) %d", tt.q, len(got), tt.once, len(want))
+			continue
+		}
+		for i := range got {
+			if got[i].Title != want[i].Title || math.Abs(got[i].Score-want[i].Score) > 1e-9 {
+				t.Errorf("search(%q)[%d] = %q %v, want %q %v as for %q", tt.q, i, got[i].Title, got[i].Score, want[i].Title, want[i].Score, tt.once)
+			}
+		}
+	}
+}
+
+func TestSearchIndexFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	write := func(body string, mod time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write(`{"docs": [{"title": "One", "len": 1}], "terms": {}}`, start)
+	f, err := newSearchIndexFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.index().Docs); n != 1 {
+		t.Fatalf("got %d docs, want 1", n)
+	}
+
+	write(`{"docs": [{"title": "One", "len": 1}, {"title": "Two", "len": 1}], "terms": {}}`, start.Add(time.Minute))
+	if n := len(f.index().Docs); n != 2 {
+		t.Errorf("after a rebuild got %d docs, want 2", n)
+	}
+
+	// A broken index keeps the last good one.
+	write(`{"docs": [`, start.Add(2*time.Minute))
+	if n := len(f.index().Docs); n != 2 {
+		t.Errorf("after a bad write got %d docs, want 2", n)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.index().Docs); n != 2 {
+		t.Errorf("after removal got %d docs, want 2", n)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// precompressed are the encodings the build writes beside compressible
+// files, each as its Content-Encoding and file suffix, in order of
+// preference when a client accepts both equally.
+var precompressed = []struct{ encoding, suffix string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticDir adds to a file server for dir: it gives every file a strong
+// ETag, so http.FileServer answers If-None-Match, and If-Modified-Since
+// from the file's modification time, with a 304. It also sends the
+// build's precompressed copy of a file when the client accepts its
+// encoding, leaving on-the-fly compression to the handler it wraps for
+// files without one.
+type staticDir struct {
+	dir   string
+	etags *etagCache
+}
+
+func newStaticDir(dir string) *staticDir {
+	return &staticDir{dir: dir, etags: newETagCache()}
+}
+
+func (s *staticDir) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, fi, ok := s.resolve(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if isCompressible(name) {
+			varyAcceptEncoding(w.Header())
+			if s.servePrecompressed(w, r, name, fi) {
+				return
+			}
+		}
+		if etag, ok := s.etags.lookup(name, fi); ok {
+			w.Header().Set("ETag", etag)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolve returns the file http.FileServer would serve for the URL path p,
+// if there is one. Paths FileServer redirects instead, such as a
+// directory without its trailing slash or one ending in /index.html, are
+// left to it, so every encoding sees the same redirect.
+func (s *staticDir) resolve(p string) (string, os.FileInfo, bool) {
+	if strings.HasSuffix(p, "/index.html") {
+		return "", nil, false
+	}
+	name := filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+p)))
+	fi, err := os.Stat(name)
+	switch {
+	case err != nil:
+		return "", nil, false
+	case fi.IsDir():
+		if !strings.HasSuffix(p, "/") {
+			return "", nil, false
+		}
+		name = filepath.Join(name, "index.html")
+		fi, err = os.Stat(name)
+	case strings.HasSuffix(p, "/"):
+		return "", nil, false
+	}
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", nil, false
+	}
+	return name, fi, true
+}
+
+// servePrecompressed serves the precompressed copy of name in the first
+// encoding the client accepts, by q-value, that the build wrote a copy
+// in, and reports whether there was one. A copy older than the file
+// itself is ignored.
+func (s *staticDir) servePrecompressed(w http.ResponseWriter, r *http.Request, name string, fi os.FileInfo) bool {
+	offers := make([]string, 0, len(precompressed))
+	for _, pc := range precompressed {
+		offers = append(offers, pc.encoding)
+	}
+	for _, encoding := range acceptedEncodings(r.Header.Get("Accept-Encoding"), offers...) {
+		for _, pc := range precompressed {
+			if pc.encoding == encoding && s.serveCopy(w, r, name, fi, pc.encoding, pc.suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveCopy serves name+suffix as name in encoding if it's there and up
+// to date.
+func (s *staticDir) serveCopy(w http.ResponseWriter, r *http.Request, name string, fi os.FileInfo, encoding, suffix string) bool {
+	f, err := os.Open(name + suffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	cfi, err := f.Stat()
+	if err != nil || !cfi.Mode().IsRegular() || cfi.ModTime().Before(fi.ModTime()) {
+		return false
+	}
+	if etag, ok := s.etags.lookup(name+suffix, cfi); ok {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	// ServeContent takes the Content-Type from the uncompressed name.
+	http.ServeContent(w, r, name, cfi.ModTime(), f)
+	return true
+}
+
+// preferredEncoding returns the offered content coding the Accept-Encoding
+// header gives the highest q-value, favoring earlier offers on ties, or ""
+// if it accepts none of them.
+func preferredEncoding(header string, offers ...string) string {
+	if accepted := acceptedEncodings(header, offers...); len(accepted) > 0 {
+		return accepted[0]
+	}
+	return ""
+}
+
+// acceptedEncodings returns the offered content codings the Accept-Encoding
+// header accepts, highest q-value first and earlier offers first on ties.
+func acceptedEncodings(header string, offers ...string) []string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					weight = f
+				}
+			}
+		}
+		q[coding] = weight
+	}
+	weight := func(offer string) float64 {
+		if w, ok := q[offer]; ok {
+			return w
+		}
+		return q["*"]
+	}
+	var accepted []string
+	for _, offer := range offers {
+		if weight(offer) > 0 {
+			accepted = append(accepted, offer)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return weight(accepted[i]) > weight(accepted[j]) })
+	return accepted
+}
+
+// varyAcceptEncoding adds Accept-Encoding to the Vary header once.
+func varyAcceptEncoding(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
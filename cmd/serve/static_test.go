@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files, keyed by slash-separated path, under dir. The
+// precompressed copies are written last, so they're never older than the
+// file they belong to.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for _, compressed := range []bool{false, true} {
+		for p, body := range files {
+			if ext := path.Ext(p); (ext == ".gz" || ext == ".br") != compressed {
+				continue
+			}
+			full := filepath.Join(dir, filepath.FromSlash(p))
+			if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(full, []byte(body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestStaticDirCanonicalPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"index.html":               "home",
+		"index.html.br":            "home br",
+		"articles/x/index.html":    "article",
+		"articles/x/index.html.br": "article br",
+		"articles/x/index.html.gz": "article gz",
+		"style.css":                "body{}",
+		"style.css.gz":             "body{} gz",
+	})
+	h := newStaticDir(dir).wrap(gzipWrap(custom404Handler(dir, false)))
+
+	tests := []struct {
+		target   string
+		encoding string // Accept-Encoding
+		status   int
+		location string
+		coding   string // Content-Encoding
+	}{
+		{"/articles/x/", "br", http.StatusOK, "", "br"},
+		{"/articles/x/", "gzip", http.StatusOK, "", "gzip"},
+		{"/articles/x/", "", http.StatusOK, "", ""},
+		{"/", "br, gzip", http.StatusOK, "", "br"},
+		{"/style.css", "gzip", http.StatusOK, "", "gzip"},
+
+		// The same redirects whatever the client accepts.
+		{"/articles/x", "", http.StatusMovedPermanently, "x/", ""},
+		{"/articles/x", "br", http.StatusMovedPermanently, "x/", ""},
+		{"/articles/x", "gzip", http.StatusMovedPermanently, "x/", ""},
+		{"/articles/x/index.html", "", http.StatusMovedPermanently, "./", ""},
+		{"/articles/x/index.html", "br", http.StatusMovedPermanently, "./", ""},
+		{"/articles/x/index.html", "gzip", http.StatusMovedPermanently, "./", ""},
+		{"/index.html", "br", http.StatusMovedPermanently, "./", ""},
+		{"/style.css/", "gzip", http.StatusMovedPermanently, "../style.css", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		if tt.encoding != "" {
+			req.Header.Set("Accept-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		coding := w.Header().Get("Content-Encoding")
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location || coding != tt.coding {
+			t.Errorf("GET %s (Accept-Encoding %q) = %d, Location %q, Content-Encoding %q; want %d, %q, %q",
+				tt.target, tt.encoding, w.Code, w.Header().Get("Location"), coding, tt.status, tt.location, tt.coding)
+		}
+	}
+}
+
+func TestStaticDirNotModified(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/index.html":    "page",
+		"a/index.html.br": "page br",
+	})
+	h := newStaticDir(dir).wrap(gzipWrap(custom404Handler(dir, false)))
+
+	for _, encoding := range []string{"", "br"} {
+		req := httptest.NewRequest("GET", "/a/", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" {
+			t.Fatalf("GET /a/ (Accept-Encoding %q) = %d, ETag %q", encoding, w.Code, etag)
+		}
+
+		req.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("GET /a/ (Accept-Encoding %q, If-None-Match %s) = %d with %d bytes, want an empty 304", encoding, etag, w.Code, w.Body.Len())
+		}
+	}
+}
+
+func TestStaticDirFallsBackToOtherCopy(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/index.html":    "page",
+		"a/index.html.gz": "page gz",
+	})
+	h := newStaticDir(dir).wrap(gzipWrap(custom404Handler(dir, false)))
+	want, _ := newETagCache().lookup(filepath.Join(dir, "a", "index.html.gz"), mustStat(t, filepath.Join(dir, "a", "index.html.gz")))
+
+	for _, encoding := range []string{"br, gzip", "br;q=1, gzip;q=0.5", "*"} {
+		req := httptest.NewRequest("GET", "/a/", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Header().Get("Content-Encoding") != "gzip" || w.Body.String() != "page gz" || w.Header().Get("ETag") != want {
+			t.Errorf("GET /a/ (Accept-Encoding %q) = Content-Encoding %q, ETag %q, body %q; want the .gz copy with ETag %s",
+				encoding, w.Header().Get("Content-Encoding"), w.Header().Get("ETag"), w.Body.String(), want)
+		}
+	}
+}
+
+func mustStat(t *testing.T, name string) os.FileInfo {
+	t.Helper()
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
+
+func TestAcceptedEncodings(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", nil},
+		{"identity", nil},
+		{"gzip", []string{"gzip"}},
+		{"gzip, br", []string{"br", "gzip"}},
+		{"br;q=0.5, gzip", []string{"gzip", "br"}},
+		{"br;q=0, gzip", []string{"gzip"}},
+		{"*", []string{"br", "gzip"}},
+		{"*;q=0.1, GZIP;q=0.8", []string{"gzip", "br"}},
+	}
+	for _, tt := range tests {
+		got := acceptedEncodings(tt.header, "br", "gzip")
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("acceptedEncodings(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
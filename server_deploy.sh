@@ -27,7 +27,7 @@ ssh -p "$SSH_PORT" -S /tmp/ssh_mux_$REMOTE_HOST "${REMOTE_USER}@${REMOTE_HOST}"
 "
 
 echo "Building site_server for linux/amd64..."
-GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -trimpath -ldflags="-s -w" -o "$OUTPUT_BINARY" ./cmd/serve/main.go
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -trimpath -ldflags="-s -w" -o "$OUTPUT_BINARY" ./cmd/serve
 
 echo "Binary built at $OUTPUT_BINARY"
 
@@ -41,6 +41,7 @@ echo "Starting remote server..."
 ssh -p "$SSH_PORT" -S /tmp/ssh_mux_$REMOTE_HOST "${REMOTE_USER}@${REMOTE_HOST}" "
   nohup ${SERVER_DIR}/site_server \
     -public \"$REMOTE_DIR\" \
+    -server \"$REMOTE_DIR.server\" \
     -css \"$REMOTE_DIR/css\" \
     -images \"$REMOTE_DIR/images\" \
     -addr \"$LISTEN_ADDR\" \
@@ -0,0 +1,158 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// AssetManifestFile is the name of the asset manifest in the server
+// directory.
+const AssetManifestFile = "asset-manifest.json"
+
+// AssetManifest lists the files a build published under fingerprinted
+// names. A fingerprinted URL changes whenever its content does, so it can
+// be cached forever; no other URL can.
+type AssetManifest struct {
+	// Assets maps the URL of each static file and image as it's written
+	// in templates and content, such as "/css/site.css", to the URL it
+	// was published under, "/css/site.1a2b3c4d.css".
+	Assets map[string]string `json:"assets"`
+	// Immutable lists every fingerprinted URL, resized images included,
+	// in order.
+	Immutable []string `json:"immutable"`
+}
+
+// ReadAssetManifest reads the manifest a build wrote to path.
+func ReadAssetManifest(path string) (*AssetManifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m AssetManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// URL returns the published URL for p, or p if it isn't an asset.
+func (m *AssetManifest) URL(p string) string {
+	if m != nil {
+		if u, ok := m.Assets[p]; ok {
+			return u
+		}
+	}
+	return p
+}
+
+// fingerprint inserts fp before the extension of name: a/b.css becomes
+// a/b.<fp>.css.
+func fingerprint(name, fp string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + fp + ext
+}
+
+// reCSSURL matches the root-relative url() references in a stylesheet.
+var reCSSURL = regexp.MustCompile(`url\((['"]?)(/[^'")]+)(['"]?)\)`)
+
+// publishStatic publishes the files of every Static directory under
+// fingerprinted names and adds them to r.assets. Stylesheets go last, with
+// their url() references pointed at the published files, so their own
+// fingerprints change with the files they refer to.
+func (r *run) publishStatic() error {
+	r.assets = map[string]string{}
+	type file struct {
+		dir, path string
+		fsys      fs.FS
+	}
+	var files []file
+	for dir, fsys := range r.Static {
+		err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				files = append(files, file{dir, p, fsys})
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		ci, cj := path.Ext(files[i].path) == ".css", path.Ext(files[j].path) == ".css"
+		if ci != cj {
+			return cj
+		}
+		return files[i].dir+"/"+files[i].path < files[j].dir+"/"+files[j].path
+	})
+
+	for _, f := range files {
+		b, err := fs.ReadFile(f.fsys, f.path)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", f.dir, f.path, err)
+		}
+		if path.Ext(f.path) == ".css" {
+			b = reCSSURL.ReplaceAllFunc(b, func(m []byte) []byte {
+				sm := reCSSURL.FindSubmatch(m)
+				return []byte("url(" + string(sm[1]) + r.asset(string(sm[2])) + string(sm[3]) + ")")
+			})
+		}
+		h := hashBytes(b)
+		name := fingerprint(f.path, h[:8])
+		outPath := filepath.Join(r.OutDir, f.dir, filepath.FromSlash(name))
+		err = r.cache.writePage(outPath, h, func(buf *bytes.Buffer) error {
+			_, err := buf.Write(b)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", f.dir, f.path, err)
+		}
+		r.assets["/"+f.dir+"/"+f.path] = "/" + f.dir + "/" + name
+	}
+	return nil
+}
+
+// asset returns the published URL for the static file or image at p, or p
+// itself if the build didn't publish one.
+func (r *run) asset(p string) string {
+	if u, ok := r.assets[p]; ok {
+		return u
+	}
+	return r.images.src(p)
+}
+
+// assetManifest collects the static files and images published so far.
+func (r *run) assetManifest() *AssetManifest {
+	m := &AssetManifest{Assets: map[string]string{}}
+	for k, v := range r.assets {
+		m.Assets[k] = v
+		m.Immutable = append(m.Immutable, v)
+	}
+	for k, img := range r.images {
+		m.Assets[k] = img.Src
+		m.Immutable = append(m.Immutable, img.Files...)
+	}
+	sort.Strings(m.Immutable)
+	return m
+}
+
+// writeAssetManifest writes the manifest to the server directory.
+func (r *run) writeAssetManifest(m *AssetManifest) error {
+	outPath := filepath.Join(r.serverDir(), AssetManifestFile)
+	return r.cache.writePage(outPath, m, func(buf *bytes.Buffer) error {
+		b, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+		return nil
+	})
+}
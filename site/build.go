@@ -0,0 +1,815 @@
+package site
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Builder renders a site from its content, templates and static files into
+// OutDir. The zero value of every optional field is usable.
+type Builder struct {
+	Config Config
+
+	// Content holds the articles/ and, optionally, notes/ directories and
+	// a redirects file.
+	Content fs.FS
+	// Templates holds the page templates and their partials.
+	Templates fs.FS
+	// Static maps a directory under OutDir, such as "css", to the files
+	// published into it under fingerprinted names. url() references in
+	// stylesheets are rewritten to match.
+	Static map[string]fs.FS
+	// Images holds the site's images, published under OutDir/images with
+	// PNG and JPEG files converted to WebP.
+	Images fs.FS
+
+	OutDir string
+	// ServerDir receives the files meant for the server rather than for
+	// browsers, the asset manifest, the redirect rules and what rendering
+	// search results takes, so they aren't published with the site. Empty
+	// means DefaultServerDir(OutDir).
+	ServerDir string
+	// CacheDir holds the build manifest that lets unchanged outputs be
+	// skipped. Empty means every output is written and no manifest is kept.
+	CacheDir string
+	// Full ignores the manifest and rewrites every output.
+	Full bool
+	// Drafts also renders drafts and scheduled items under drafts/.
+	Drafts bool
+	// Now is the time the build treats as the present. Zero means time.Now.
+	Now time.Time
+	// Jobs caps how many files are parsed or rendered at once. Zero means
+	// one per CPU. Output doesn't depend on it.
+	Jobs int
+
+	Log *log.Logger // defaults to log.Default()
+}
+
+// DefaultServerDir is the server directory beside outDir, e.g.
+// public.server for public.
+func DefaultServerDir(outDir string) string {
+	return filepath.Clean(outDir) + ".server"
+}
+
+// serverDir is ServerDir or its default.
+func (r *run) serverDir() string {
+	if r.ServerDir != "" {
+		return r.ServerDir
+	}
+	return DefaultServerDir(r.OutDir)
+}
+
+// Result summarizes a finished build.
+type Result struct {
+	Written   int
+	Unchanged int
+	Drafts    []string // URL paths of the rendered draft previews
+	Queued    []Queued // items held back by their date, soonest first
+}
+
+// run is the state of one Build call.
+type run struct {
+	*Builder
+	now    time.Time
+	log    *log.Logger
+	cache  *buildCache
+	images imageSet          // set by processImages
+	assets map[string]string // static file URL -> published URL, set by publishStatic
+
+	// imageCacheUsed holds the files in CacheDir/images this build used or
+	// would have, had its outputs not been up to date.
+	imageCacheMu   sync.Mutex
+	imageCacheUsed map[string]bool
+
+	articleTpl, listTpl, noteTpl, noteListTpl, searchTpl, tpl404 *template.Template
+	shortcodes                                                   map[string]*template.Template
+	redirects                                                    Redirects // hand-written, from the content root
+
+	arts, draftArts   []Article
+	notes, draftNotes []Note
+	heldArts          []Article // scheduled and not previewed, so only validated
+	heldNotes         []Note
+	queued            []Queued
+	drafts            []string
+	problems          []error
+	sitemap           []sitemapURL   // every indexable page
+	pages             []func() error // queued writes, see page
+}
+
+// Build renders the whole site. If the templates or any content file
+// has problems, nothing is written and the returned error joins all of
+// them.
+func (b *Builder) Build() (*Result, error) {
+	r, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+
+	siteHash, err := hashJSON(b.Config)
+	if err != nil {
+		return nil, err
+	}
+	templateHash, err := hashFS(b.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("hash templates: %w", err)
+	}
+	manifest := ""
+	if b.CacheDir != "" {
+		manifest = filepath.Join(b.CacheDir, "manifest.json")
+	}
+	r.cache = loadBuildCache(manifest, b.OutDir, siteHash, templateHash, b.Full, r.log)
+
+	// Images and static files go first, since pages refer to them by
+	// their fingerprinted names.
+	if err := r.processImages(); err != nil {
+		return nil, err
+	}
+	if err := r.publishStatic(); err != nil {
+		return nil, err
+	}
+	assets := r.assetManifest()
+	assetsHash, err := hashJSON(assets)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.setAssets(assetsHash)
+	for _, tpl := range []*template.Template{r.articleTpl, r.listTpl, r.noteTpl, r.noteListTpl, r.searchTpl, r.tpl404} {
+		tpl.Funcs(template.FuncMap{"image": r.images.src, "asset": r.asset})
+	}
+	if err := r.render(); err != nil {
+		return nil, err
+	}
+	if err := r.writeAssetManifest(assets); err != nil {
+		return nil, fmt.Errorf("write %s: %w", AssetManifestFile, err)
+	}
+	// Redirects go last, as they're checked against every page written.
+	if err := r.writeRedirects(); err != nil {
+		return nil, fmt.Errorf("write %s: %w", RedirectsFile, err)
+	}
+
+	r.cache.prune()
+	if err := r.cache.save(); err != nil {
+		return nil, fmt.Errorf("write build manifest: %w", err)
+	}
+
+	sort.Slice(r.queued, func(i, j int) bool { return r.queued[i].At.Before(r.queued[j].At) })
+	return &Result{
+		Written:   r.cache.written,
+		Unchanged: r.cache.skipped,
+		Drafts:    r.drafts,
+		Queued:    r.queued,
+	}, nil
+}
+
+// page queues an output write to run once render has gathered the data
+// for every page.
+func (r *run) page(write func() error) {
+	r.pages = append(r.pages, write)
+}
+
+// parallel calls f(0) through f(n-1) on up to Jobs goroutines. Errors are
+// joined in index order, so the result doesn't depend on scheduling.
+func (r *run) parallel(n int, f func(i int) error) error {
+	jobs := r.Jobs
+	if jobs < 1 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, n) {
+		wg.Go(func() {
+			for i := range next {
+				errs[i] = f(i)
+			}
+		})
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Check parses the templates and loads the content as Build would,
+// without writing anything. The returned error joins every problem found.
+func (b *Builder) Check() error {
+	_, err := b.load()
+	return err
+}
+
+// load parses the templates and reads all content, collecting problems
+// instead of stopping at the first.
+func (b *Builder) load() (*run, error) {
+	r := &run{Builder: b, now: b.Now, log: b.Log}
+	if r.now.IsZero() {
+		r.now = time.Now()
+	}
+	if r.log == nil {
+		r.log = log.Default()
+	}
+
+	for _, t := range []struct {
+		dst  **template.Template
+		name string
+	}{
+		{&r.articleTpl, "article.html.tmpl"},
+		{&r.listTpl, "list.html.tmpl"},
+		{&r.noteTpl, "note.html.tmpl"},
+		{&r.noteListTpl, "note_list.html.tmpl"},
+		{&r.searchTpl, "search.html.tmpl"},
+		{&r.tpl404, "404.html.tmpl"},
+	} {
+		tpl, err := ParseTemplate(b.Templates, t.name)
+		if err != nil {
+			r.problems = append(r.problems, err)
+			continue
+		}
+		*t.dst = tpl
+	}
+	shortcodes, err := parseShortcodes(b.Templates)
+	if err != nil {
+		r.problems = append(r.problems, err)
+	}
+	r.shortcodes = shortcodes
+	r.loadRedirects()
+	r.loadArticles()
+	r.loadNotes()
+	r.validate()
+	return r, errors.Join(r.problems...)
+}
+
+type articleView struct {
+	Site         Config
+	Slug         string
+	Title        string
+	Date         string
+	DateHuman    string
+	Author       Author
+	Tags         []Tag
+	ContentHTML  template.HTML
+	TOC          template.HTML // nested list of the h2 and h3 headings, if asked for
+	CanonicalURL *string
+	Hero         *Hero
+	Card         string // URL of the social card, "" when there's a hero
+	Prev         *articleLink
+	Next         *articleLink
+	Draft        bool
+}
+
+// articleLink is what an article page shows of its neighbours. It holds
+// no more than the template renders, so that editing one article doesn't
+// change the pages on either side of it.
+type articleLink struct {
+	Slug  string
+	Title string
+}
+
+func newArticleLink(a *Article) *articleLink {
+	if a == nil {
+		return nil
+	}
+	return &articleLink{Slug: a.Slug, Title: a.Title}
+}
+
+func newArticleView(site Config, a Article, img imageSet) articleView {
+	// Point the hero at the published version of the image
+	var hero *Hero
+	card := ""
+	if a.Hero != nil {
+		hero = img.hero(a.Hero)
+	} else {
+		card = "/og/articles/" + a.Slug + ".png"
+	}
+	contentHTML := img.rewrite(a.ContentHTML)
+	var toc template.HTML
+	if a.TOC {
+		toc = tableOfContents(contentHTML)
+	}
+	return articleView{
+		Site:         site,
+		Slug:         a.Slug,
+		Title:        a.Title,
+		Date:         a.Date,
+		DateHuman:    humanDate(a.t),
+		Author:       a.Author,
+		Tags:         a.Tags,
+		ContentHTML:  template.HTML(contentHTML),
+		TOC:          toc,
+		CanonicalURL: a.CanonicalURL,
+		Hero:         hero,
+		Card:         card,
+		Prev:         newArticleLink(a.Prev),
+		Next:         newArticleLink(a.Next),
+		Draft:        a.Draft,
+	}
+}
+
+// ListItem is one entry on a list page, as list.html.tmpl sees it. The
+// server renders search results with the same template and types.
+type ListItem struct {
+	Title     string
+	URL       string
+	ISODate   string
+	HumanDate string
+	Type      string // "article" or "note"
+	Count     int    // number of entries behind the link, for index pages
+	modified  time.Time
+}
+
+// ListView is the data list.html.tmpl is executed with.
+type ListView struct {
+	Site     Config
+	Title    string
+	Subtitle string
+	Items    []ListItem
+	Feed     string // URL path of this list's own feeds, if it has them
+}
+
+type noteView struct {
+	Site        Config
+	Slug        string
+	Title       string
+	Date        string
+	DateHuman   string
+	Author      Author
+	Tags        []Tag
+	Source      *string
+	ContentHTML template.HTML
+	Card        string // URL of the social card
+	Draft       bool
+}
+
+func newNoteView(site Config, n Note, img imageSet) noteView {
+	return noteView{
+		Site:        site,
+		Slug:        n.Slug,
+		Title:       n.Title,
+		Date:        n.Date,
+		DateHuman:   humanDate(n.t),
+		Author:      n.Author,
+		Tags:        n.Tags,
+		Source:      n.Source,
+		ContentHTML: template.HTML(img.rewrite(n.ContentHTML)),
+		Card:        "/og/notes/" + n.Slug + ".png",
+		Draft:       n.Draft,
+	}
+}
+
+type paginatedListView struct {
+	Site        Config
+	Title       string
+	Subtitle    string
+	Items       []ListItem
+	CurrentPage int
+	TotalPages  int
+	PrevURL     string
+	NextURL     string
+}
+
+// render writes every page, feed and index for the loaded content. The
+// data for each output is gathered here in order; the writes themselves
+// are queued with page and run in parallel at the end.
+func (r *run) render() error {
+	siteCfg, outDir := r.Config, r.OutDir
+
+	// Prepare maps
+	tagMap := map[string]struct {
+		Name  string
+		Items []ListItem
+	}{}
+	ymMap := map[string][]ListItem{} // key "YYYY/MM"
+
+	// Render articles
+	for _, a := range r.arts {
+		av := newArticleView(siteCfg, a, r.images)
+		outPath := filepath.Join(outDir, "articles", a.Slug, "index.html")
+		r.page(func() error {
+			if err := r.cache.writePage(outPath, av, func(buf *bytes.Buffer) error {
+				return r.articleTpl.Execute(buf, av)
+			}); err != nil {
+				return fmt.Errorf("render article %s: %w", a.Slug, err)
+			}
+			return nil
+		})
+		r.cardPage(av.Card, newCard(siteCfg, a.Title, av.DateHuman, a.Tags))
+
+		item := ListItem{
+			Title:     a.Title,
+			URL:       "/articles/" + a.Slug + "/",
+			ISODate:   a.Date,
+			HumanDate: humanDate(a.t),
+			Type:      "article",
+			modified:  articleModified(a),
+		}
+		r.sitemap = append(r.sitemap, sitemapURL{Loc: siteCfg.URL + item.URL, LastMod: item.modified})
+
+		// tags
+		for _, tg := range a.Tags {
+			entry := tagMap[tg.Slug]
+			entry.Name = tg.Name
+			entry.Items = append(entry.Items, item)
+			tagMap[tg.Slug] = entry
+		}
+
+		// archive buckets
+		ym := a.t.Format("2006/01")
+		ymMap[ym] = append(ymMap[ym], item)
+	}
+
+	// Render notes
+	var noteItems []ListItem
+	for _, n := range r.notes {
+		nv := newNoteView(siteCfg, n, r.images)
+		outPath := filepath.Join(outDir, "notes", n.Slug, "index.html")
+		r.page(func() error {
+			if err := r.cache.writePage(outPath, nv, func(buf *bytes.Buffer) error {
+				return r.noteTpl.Execute(buf, nv)
+			}); err != nil {
+				return fmt.Errorf("render note %s: %w", n.Slug, err)
+			}
+			return nil
+		})
+		r.cardPage(nv.Card, newCard(siteCfg, n.Title, nv.DateHuman, n.Tags))
+
+		item := ListItem{
+			Title:     n.Title,
+			URL:       "/notes/" + n.Slug + "/",
+			ISODate:   n.Date,
+			HumanDate: humanDate(n.t),
+			Type:      "note",
+			modified:  n.t,
+		}
+		noteItems = append(noteItems, item)
+		r.sitemap = append(r.sitemap, sitemapURL{Loc: siteCfg.URL + item.URL, LastMod: item.modified})
+
+		// add to tags
+		for _, tg := range n.Tags {
+			entry := tagMap[tg.Slug]
+			entry.Name = tg.Name
+			entry.Items = append(entry.Items, item)
+			tagMap[tg.Slug] = entry
+		}
+	}
+
+	// Render drafts for preview. They live under /drafts/ and stay out of
+	// every list, tag page and feed.
+	for _, a := range r.draftArts {
+		av := newArticleView(siteCfg, a, r.images)
+		if av.Card != "" {
+			av.Card = "/drafts" + av.Card
+		}
+		outPath := filepath.Join(outDir, "drafts", "articles", a.Slug, "index.html")
+		r.page(func() error {
+			if err := r.cache.writePage(outPath, av, func(buf *bytes.Buffer) error {
+				return r.articleTpl.Execute(buf, av)
+			}); err != nil {
+				return fmt.Errorf("render draft article %s: %w", a.Slug, err)
+			}
+			return nil
+		})
+		r.cardPage(av.Card, newCard(siteCfg, a.Title, av.DateHuman, a.Tags))
+		r.drafts = append(r.drafts, "/drafts/articles/"+a.Slug+"/")
+	}
+	for _, n := range r.draftNotes {
+		nv := newNoteView(siteCfg, n, r.images)
+		nv.Card = "/drafts" + nv.Card
+		outPath := filepath.Join(outDir, "drafts", "notes", n.Slug, "index.html")
+		r.page(func() error {
+			if err := r.cache.writePage(outPath, nv, func(buf *bytes.Buffer) error {
+				return r.noteTpl.Execute(buf, nv)
+			}); err != nil {
+				return fmt.Errorf("render draft note %s: %w", n.Slug, err)
+			}
+			return nil
+		})
+		r.cardPage(nv.Card, newCard(siteCfg, n.Title, nv.DateHuman, n.Tags))
+		r.drafts = append(r.drafts, "/drafts/notes/"+n.Slug+"/")
+	}
+
+	// Render tag pages
+	for slug, v := range tagMap {
+		sort.Slice(v.Items, func(i, j int) bool { return v.Items[i].ISODate > v.Items[j].ISODate })
+		lv := ListView{Site: siteCfg, Title: "Tag: " + v.Name, Items: v.Items, Feed: "/tag/" + slug + "/"}
+		r.page(func() error {
+			if err := r.writeList(filepath.Join(outDir, "tag", slug, "index.html"), lv); err != nil {
+				return err
+			}
+			return nil
+		})
+		r.sitemap = append(r.sitemap, sitemapURL{Loc: siteCfg.URL + "/tag/" + slug + "/", LastMod: lastModified(v.Items)})
+	}
+
+	// Tag index
+	var tagItems []ListItem
+	for slug, v := range tagMap {
+		tagItems = append(tagItems, ListItem{
+			Title:    v.Name,
+			URL:      "/tag/" + slug + "/",
+			Count:    len(v.Items),
+			modified: lastModified(v.Items),
+		})
+	}
+	sort.Slice(tagItems, func(i, j int) bool {
+		return strings.ToLower(tagItems[i].Title) < strings.ToLower(tagItems[j].Title)
+	})
+	r.page(func() error {
+		if err := r.writeList(filepath.Join(outDir, "tag", "index.html"), ListView{
+			Site:  siteCfg,
+			Title: "Tags",
+			Items: tagItems,
+		}); err != nil {
+			return err
+		}
+		return nil
+	})
+	r.sitemap = append(r.sitemap, sitemapURL{Loc: siteCfg.URL + "/tag/", LastMod: lastModified(tagItems)})
+
+	// Render archive month pages and archive index
+	type ymEntry struct {
+		Key   string
+		Items []ListItem
+	}
+	var months []ymEntry
+	for k, items := range ymMap {
+		sort.Slice(items, func(i, j int) bool { return items[i].ISODate > items[j].ISODate })
+		months = append(months, ymEntry{Key: k, Items: items})
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Key > months[j].Key })
+
+	// month pages
+	for _, m := range months {
+		title := "Archive " + humanMonth(m.Key)
+		lv := ListView{Site: siteCfg, Title: title, Items: m.Items}
+		r.page(func() error {
+			if err := r.writeList(filepath.Join(outDir, "archive", m.Key, "index.html"), lv); err != nil {
+				return err
+			}
+			return nil
+		})
+		r.sitemap = append(r.sitemap, sitemapURL{Loc: siteCfg.URL + "/archive/" + m.Key + "/", LastMod: lastModified(m.Items)})
+	}
+	// archive index
+	var idxItems []ListItem
+	for _, m := range months {
+		idxItems = append(idxItems, ListItem{
+			Title:     humanMonth(m.Key),
+			URL:       "/archive/" + m.Key + "/",
+			ISODate:   m.Key,
+			HumanDate: humanMonth(m.Key),
+			modified:  lastModified(m.Items),
+		})
+	}
+	r.page(func() error {
+		if err := r.writeList(filepath.Join(outDir, "archive", "index.html"), ListView{
+			Site:     siteCfg,
+			Title:    "Archive",
+			Subtitle: "By month",
+			Items:    idxItems,
+		}); err != nil {
+			return err
+		}
+		return nil
+	})
+	r.sitemap = append(r.sitemap, sitemapURL{Loc: siteCfg.URL + "/archive/", LastMod: lastModified(idxItems)})
+
+	// Render notes list with pagination
+	const notesPerPage = 20
+	totalNotePages := (len(noteItems) + notesPerPage - 1) / notesPerPage
+	if totalNotePages < 1 {
+		totalNotePages = 1
+	}
+	for page := 1; page <= totalNotePages; page++ {
+		start := (page - 1) * notesPerPage
+		end := start + notesPerPage
+		if end > len(noteItems) {
+			end = len(noteItems)
+		}
+		pageItems := noteItems[start:end]
+
+		var prevURL, nextURL string
+		if page > 1 {
+			if page == 2 {
+				prevURL = "/notes/"
+			} else {
+				prevURL = "/notes/page/" + strconv.Itoa(page-1) + "/"
+			}
+		}
+		if page < totalNotePages {
+			nextURL = "/notes/page/" + strconv.Itoa(page+1) + "/"
+		}
+
+		plv := paginatedListView{
+			Site:        siteCfg,
+			Title:       "Notes",
+			Subtitle:    "Quick reference notes",
+			Items:       pageItems,
+			CurrentPage: page,
+			TotalPages:  totalNotePages,
+			PrevURL:     prevURL,
+			NextURL:     nextURL,
+		}
+
+		var outPath, pageURL string
+		if page == 1 {
+			outPath = filepath.Join(outDir, "notes", "index.html")
+			pageURL = "/notes/"
+		} else {
+			outPath = filepath.Join(outDir, "notes", "page", strconv.Itoa(page), "index.html")
+			pageURL = "/notes/page/" + strconv.Itoa(page) + "/"
+		}
+		r.sitemap = append(r.sitemap, sitemapURL{Loc: siteCfg.URL + pageURL, LastMod: lastModified(pageItems)})
+
+		r.page(func() error {
+			if err := r.cache.writePage(outPath, plv, func(buf *bytes.Buffer) error {
+				return r.noteListTpl.Execute(buf, plv)
+			}); err != nil {
+				return fmt.Errorf("render notes list page %d: %w", page, err)
+			}
+			return nil
+		})
+	}
+
+	// Generate feeds (RSS, Atom and JSON Feed)
+	var postEntries []feedEntry
+	for _, a := range r.arts {
+		postEntries = append(postEntries, articleFeedEntry(siteCfg, a, r.images))
+	}
+	r.page(func() error {
+		if err := r.writeFeeds(feedInfo{
+			Title:       siteCfg.Name + " - Posts",
+			Link:        siteCfg.URL,
+			Description: siteCfg.Description,
+			Dir:         "/",
+		}, postEntries); err != nil {
+			return fmt.Errorf("write posts feeds: %w", err)
+		}
+		return nil
+	})
+
+	var noteEntries []feedEntry
+	for _, n := range r.notes {
+		noteEntries = append(noteEntries, noteFeedEntry(siteCfg, n, r.images))
+	}
+	r.page(func() error {
+		if err := r.writeFeeds(feedInfo{
+			Title:       siteCfg.Name + " - Notes",
+			Link:        siteCfg.URL + "/notes/",
+			Description: "Quick reference notes from " + siteCfg.Name,
+			Dir:         "/notes/",
+		}, noteEntries); err != nil {
+			return fmt.Errorf("write notes feeds: %w", err)
+		}
+		return nil
+	})
+
+	// Per-tag feeds and the combined firehose, both mixing articles and notes
+	allEntries := append(append([]feedEntry{}, postEntries...), noteEntries...)
+	sort.SliceStable(allEntries, func(i, j int) bool { return allEntries[i].Published.After(allEntries[j].Published) })
+	tagEntries := map[string][]feedEntry{}
+	for _, e := range allEntries {
+		for _, tg := range e.Tags {
+			tagEntries[tg.Slug] = append(tagEntries[tg.Slug], e)
+		}
+	}
+	for slug, entries := range tagEntries {
+		r.page(func() error {
+			if err := r.writeFeeds(feedInfo{
+				Title:       siteCfg.Name + " - Tag: " + tagMap[slug].Name,
+				Link:        siteCfg.URL + "/tag/" + slug + "/",
+				Description: "Posts and notes tagged " + tagMap[slug].Name,
+				Dir:         "/tag/" + slug + "/",
+			}, entries); err != nil {
+				return fmt.Errorf("write tag %s feeds: %w", slug, err)
+			}
+			return nil
+		})
+	}
+	r.page(func() error {
+		if err := r.writeFeeds(feedInfo{
+			Title:       siteCfg.Name + " - Everything",
+			Link:        siteCfg.URL,
+			Description: "All posts and notes from " + siteCfg.Name,
+			Dir:         "/all/",
+		}, allEntries); err != nil {
+			return fmt.Errorf("write combined feeds: %w", err)
+		}
+		return nil
+	})
+
+	// simple home index (latest N)
+	var homeItems []ListItem
+	for i, it := range allItems(r.arts) {
+		if i >= 12 {
+			break
+		}
+		homeItems = append(homeItems, it)
+	}
+	r.page(func() error {
+		if err := r.writeList(filepath.Join(outDir, "index.html"), ListView{
+			Site:     siteCfg,
+			Title:    siteCfg.Name,
+			Subtitle: siteCfg.AuthorEmail,
+			Items:    homeItems,
+		}); err != nil {
+			return err
+		}
+		return nil
+	})
+	r.sitemap = append(r.sitemap, sitemapURL{Loc: siteCfg.URL + "/", LastMod: lastModified(homeItems)})
+
+	// sitemap.xml and robots.txt. Drafts and the 404 page are left out.
+	r.page(func() error {
+		if err := r.writeSitemap(filepath.Join(outDir, "sitemap.xml"), r.sitemap); err != nil {
+			return fmt.Errorf("write sitemap: %w", err)
+		}
+		return nil
+	})
+	r.page(func() error {
+		if err := r.writeRobots(filepath.Join(outDir, "robots.txt")); err != nil {
+			return fmt.Errorf("write robots.txt: %w", err)
+		}
+		return nil
+	})
+
+	// Search index and page
+	r.page(func() error {
+		if err := r.writeSearchIndex(filepath.Join(outDir, "search", "index.json")); err != nil {
+			return fmt.Errorf("write search index: %w", err)
+		}
+		return nil
+	})
+	r.page(func() error {
+		if err := r.writeSearchTemplates(); err != nil {
+			return fmt.Errorf("copy search templates: %w", err)
+		}
+		return nil
+	})
+	sv := struct{ Site Config }{Site: siteCfg}
+	r.page(func() error {
+		if err := r.cache.writePage(filepath.Join(outDir, "search", "index.html"), sv, func(buf *bytes.Buffer) error {
+			return r.searchTpl.Execute(buf, sv)
+		}); err != nil {
+			return fmt.Errorf("render search page: %w", err)
+		}
+		return nil
+	})
+
+	// Generate 404 page
+	v404 := struct{ Site Config }{Site: siteCfg}
+	r.page(func() error {
+		if err := r.cache.writePage(filepath.Join(outDir, "404.html"), v404, func(buf *bytes.Buffer) error {
+			return r.tpl404.Execute(buf, v404)
+		}); err != nil {
+			return fmt.Errorf("render 404: %w", err)
+		}
+		return nil
+	})
+	return r.parallel(len(r.pages), func(i int) error { return r.pages[i]() })
+}
+
+func allItems(arts []Article) []ListItem {
+	var items []ListItem
+	for _, a := range arts {
+		items = append(items, ListItem{
+			Title:     a.Title,
+			URL:       "/articles/" + a.Slug + "/",
+			ISODate:   a.Date,
+			HumanDate: humanDate(a.t),
+			modified:  articleModified(a),
+		})
+	}
+	return items
+}
+
+// lastModified is the newest modification time among items.
+func lastModified(items []ListItem) time.Time {
+	var t time.Time
+	for _, it := range items {
+		if it.modified.After(t) {
+			t = it.modified
+		}
+	}
+	return t
+}
+
+func (r *run) writeList(outPath string, lv ListView) error {
+	if err := r.cache.writePage(outPath, lv, func(buf *bytes.Buffer) error {
+		return r.listTpl.Execute(buf, lv)
+	}); err != nil {
+		return fmt.Errorf("render list %s: %w", outPath, err)
+	}
+	return nil
+}
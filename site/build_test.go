@@ -0,0 +1,590 @@
+package site
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// testTemplates are cut-down page templates that print the data each page
+// gets, so the golden files change with the builder rather than with the
+// site's design.
+var testTemplates = map[string]string{
+	"article.html.tmpl": `<!doctype html>
+<title>{{ .Title }} · {{ .Site.Name }}</title>
+<link rel="stylesheet" href="{{ asset "/css/site.css" }}">
+<h1>{{ .Title }}</h1>
+<p>{{ template "byline" . }}</p>
+{{ range .Tags }}<a href="/tag/{{ .Slug }}/">{{ .Name }}</a>
+{{ end }}{{ with .TOC }}<nav>{{ . }}</nav>
+{{ end }}{{ .ContentHTML }}
+{{ with .Prev }}<a rel="prev" href="/articles/{{ .Slug }}/">{{ .Title }}</a>
+{{ end }}{{ with .Next }}<a rel="next" href="/articles/{{ .Slug }}/">{{ .Title }}</a>
+{{ end }}`,
+	"note.html.tmpl": `<!doctype html>
+<title>{{ .Title }} · {{ .Site.Name }}</title>
+<h1>{{ .Title }}</h1>
+<p>{{ template "byline" . }}{{ with .Source }} · from {{ . }}{{ end }}</p>
+{{ range .Tags }}<a href="/tag/{{ .Slug }}/">{{ .Name }}</a>
+{{ end }}{{ .ContentHTML }}
+`,
+	"list.html.tmpl": `<!doctype html>
+<title>{{ .Title }} · {{ .Site.Name }}</title>
+<h1>{{ .Title }}</h1>
+{{ with .Subtitle }}<p>{{ . }}</p>
+{{ end }}<ul>
+{{ range .Items }}<li>{{ .Type }} <a href="{{ .URL }}">{{ .Title }}</a>{{ with .ISODate }} <time datetime="{{ . }}">{{ end }}{{ .HumanDate }}{{ if .ISODate }}</time>{{ end }}{{ with .Count }} ({{ . }}){{ end }}</li>
+{{ end }}</ul>
+{{ with .Feed }}<a href="{{ . }}feed.xml">feed</a>
+{{ end }}`,
+	"note_list.html.tmpl": `<!doctype html>
+<h1>{{ .Title }}</h1>
+<ul>
+{{ range .Items }}<li><a href="{{ .URL }}">{{ .Title }}</a></li>
+{{ end }}</ul>
+<p>page {{ .CurrentPage }} of {{ .TotalPages }}</p>
+`,
+	"search.html.tmpl": `<!doctype html><title>Search · {{ .Site.Name }}</title>
+`,
+	"404.html.tmpl": `<!doctype html><title>Not found · {{ .Site.Name }}</title>
+`,
+	"byline.html.tmpl":             `{{define "byline"}}{{ with .Author.Name }}{{ . }}{{ else }}{{ .Site.AuthorName }}{{ end }} · <time datetime="{{ .Date }}">{{ .DateHuman }}</time>{{ end }}`,
+	"shortcodes/callout.html.tmpl": `<aside class="callout">{{ .Inner }}</aside>`,
+}
+
+// testContent is a small site: two Markdown articles and a JSON one, two
+// notes, a draft and a scheduled article that mustn't be published, and
+// hand-written redirects.
+var testContent = map[string]string{
+	"articles/first.md": `---
+slug: first-post
+title: First Post
+date: 2025-03-01
+author:
+  name: Ann Author
+tags:
+  - name: Go
+    slug: go
+  - name: Meta
+    slug: meta
+---
+Hello, **world**. See [the second post](/articles/second-post/#details).
+
+{{< callout >}}Mind the *gap*.{{< /callout >}}
+`,
+	"articles/second.md": `---
+slug: second-post
+title: Second Post
+date: 2025-04-02
+updated: 2025-05-01
+summary: The one with the details.
+tags:
+  - name: Go
+    slug: go
+toc: true
+aliases:
+  - second
+---
+## Details
+
+` + "```go\nfunc main() {}\n```" + `
+
+## More
+
+Done.
+`,
+	"articles/third.json": `{
+  "slug": "third-post",
+  "title": "Third Post",
+  "date": "2025-02-01",
+  "tags": [{"name": "Meta", "slug": "meta"}],
+  "content_html": "<p>Written as HTML.</p>"
+}`,
+	"articles/draft.md": `---
+slug: draft-post
+title: Draft Post
+date: 2025-01-01
+draft: true
+---
+Not yet.
+`,
+	"articles/later.md": `---
+slug: later-post
+title: Later Post
+date: 2026-06-01
+---
+Not yet either.
+`,
+	"notes/tip.md": `---
+slug: tip
+title: A Tip
+date: 2025-03-15T09:30
+source: a friend
+tags:
+  - name: Go
+    slug: go
+---
+Use ` + "`go vet`" + `.
+`,
+	"notes/other.md": `---
+slug: other
+title: Another Note
+date: 2025-04-20
+---
+Short.
+`,
+	"redirects": "/old/* /articles/:splat\n",
+}
+
+// goldenOutputs are the outputs compared against testdata/golden.
+var goldenOutputs = []string{
+	"articles/first-post/index.html",
+	"articles/second-post/index.html",
+	"notes/tip/index.html",
+	"index.html",
+	"tag/index.html",
+	"tag/go/index.html",
+	"tag/meta/index.html",
+	"feed.xml",
+	"feed.json",
+}
+
+func mapFS(files map[string]string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, body := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(body)}
+	}
+	return fsys
+}
+
+// testBuilder returns a Builder for the test site writing to outDir, and
+// keeping its manifest in cacheDir if that isn't empty.
+func testBuilder(outDir, cacheDir string) *Builder {
+	return &Builder{
+		Config: Config{
+			URL:         "https://example.com",
+			Name:        "Example",
+			Description: "A site for tests",
+			AuthorName:  "Site Owner",
+		},
+		Content:   mapFS(testContent),
+		Templates: mapFS(testTemplates),
+		Static: map[string]fs.FS{
+			"css": mapFS(map[string]string{"site.css": "body { color: black; }\n"}),
+		},
+		OutDir:   outDir,
+		CacheDir: cacheDir,
+		Now:      time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+		Log:      log.New(io.Discard, "", 0),
+	}
+}
+
+func TestBuildGolden(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "public")
+	res, err := testBuilder(out, "").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Queued) != 1 || res.Queued[0].URL != "/articles/later-post/" {
+		t.Errorf("queued = %v, want only /articles/later-post/", res.Queued)
+	}
+	for _, p := range []string{"articles/draft-post", "articles/later-post", "drafts"} {
+		if _, err := os.Stat(filepath.Join(out, p)); err == nil {
+			t.Errorf("%s was published", p)
+		}
+	}
+
+	for _, name := range goldenOutputs {
+		got, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		golden := filepath.Join("testdata", "golden", filepath.FromSlash(name))
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(golden, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%v (run go test -update to create it)", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from %s (run go test -update to accept it):\n%s", name, golden, got)
+		}
+	}
+}
+
+// The output, manifest included, mustn't depend on Jobs. testBuilder's
+// fixed Now keeps the feeds' build dates equal.
+func TestBuildSameForAnyJobs(t *testing.T) {
+	trees := map[int]map[string]string{}
+	for _, jobs := range []int{1, 8} {
+		root := t.TempDir()
+		b := testBuilder(filepath.Join(root, "public"), filepath.Join(root, "cache"))
+		b.Jobs = jobs
+		if _, err := b.Build(); err != nil {
+			t.Fatal(err)
+		}
+		tree := map[string]string{}
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			b, err := os.ReadFile(p)
+			rel, _ := filepath.Rel(root, p)
+			tree[filepath.ToSlash(rel)] = string(b)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		trees[jobs] = tree
+	}
+
+	one, eight := trees[1], trees[8]
+	for name, body := range one {
+		if other, ok := eight[name]; !ok {
+			t.Errorf("%s is written with 1 job but not with 8", name)
+		} else if other != body {
+			t.Errorf("%s differs between 1 job and 8", name)
+		}
+	}
+	for name := range eight {
+		if _, ok := one[name]; !ok {
+			t.Errorf("%s is written with 8 jobs but not with 1", name)
+		}
+	}
+}
+
+// Images are always published as WebP, so without cgo, which the encoder
+// needs, the build fails instead of publishing something else.
+func TestBuildImagesNeedWebP(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 600, 400))); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "public")
+	b := testBuilder(out, "")
+	b.Images = fstest.MapFS{"photo.png": &fstest.MapFile{Data: buf.Bytes()}}
+	_, err := b.Build()
+	if !webpSupported {
+		if err == nil || !strings.Contains(err.Error(), "CGO_ENABLED=1") {
+			t.Errorf("Build without cgo = %v, want an error asking for cgo", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, _ := filepath.Glob(filepath.Join(out, "images", "*"))
+	for _, p := range published {
+		if filepath.Ext(p) != ".webp" {
+			t.Errorf("published %s, want only WebP files", filepath.Base(p))
+		}
+	}
+	if len(published) != 2 {
+		t.Errorf("published %d images, want photo and its 480w copy", len(published))
+	}
+}
+
+func TestBuildPrunesImageCache(t *testing.T) {
+	if !webpSupported {
+		t.Skip("images need cgo")
+	}
+	photo := func(w, h int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	dir := t.TempDir()
+	b := testBuilder(filepath.Join(dir, "public"), filepath.Join(dir, "cache"))
+	images := fstest.MapFS{"photo.png": &fstest.MapFile{Data: photo(1000, 500)}}
+	b.Images = images
+	cached := func() []string {
+		t.Helper()
+		if _, err := b.Build(); err != nil {
+			t.Fatal(err)
+		}
+		names, err := filepath.Glob(filepath.Join(dir, "cache", "images", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	// The full-size WebP and the 480w and 960w copies.
+	first := cached()
+	if len(first) != 3 {
+		t.Fatalf("cached %d encodings, want 3", len(first))
+	}
+	// A rebuild that encodes nothing still keeps what it would use.
+	if again := cached(); strings.Join(again, "\n") != strings.Join(first, "\n") {
+		t.Errorf("after an unchanged rebuild the cache holds\n%s\nwant\n%s", strings.Join(again, "\n"), strings.Join(first, "\n"))
+	}
+	images["photo.png"] = &fstest.MapFile{Data: photo(1000, 600)}
+	edited := cached()
+	if len(edited) != 3 {
+		t.Errorf("after editing the image the cache holds %d encodings, want 3", len(edited))
+	}
+	for _, p := range first {
+		if _, err := os.Stat(p); err == nil {
+			t.Errorf("%s was kept after its image changed", filepath.Base(p))
+		}
+	}
+	delete(images, "photo.png")
+	if gone := cached(); len(gone) != 0 {
+		t.Errorf("after deleting the image the cache holds %d encodings, want none", len(gone))
+	}
+}
+
+// Images used to be published under their own names, and as .webp for
+// PNG and JPEG, so those URLs redirect to the fingerprinted files.
+func TestBuildRedirectsUnhashedImages(t *testing.T) {
+	if !webpSupported {
+		t.Skip("images need cgo")
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "public")
+	b := testBuilder(out, "")
+	b.Images = fstest.MapFS{
+		"a/photo.png": &fstest.MapFile{Data: buf.Bytes()},
+		"logo.svg":    &fstest.MapFile{Data: []byte("<svg/>")},
+	}
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.ReadFile(filepath.Join(DefaultServerDir(out), RedirectsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ParseRedirects(RedirectsFile, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, from := range []string{"/images/a/photo.png", "/images/a/photo.webp", "/images/logo.svg"} {
+		to, status, ok := rules.Match(from)
+		if !ok || status != http.StatusFound {
+			t.Errorf("%s: got %q, %d, %v; want a 302", from, to, status, ok)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(out, filepath.FromSlash(to))); err != nil || to == from {
+			t.Errorf("%s redirects to %s, which isn't published", from, to)
+		}
+	}
+	if to, _, ok := rules.Match("/images/logo.webp"); ok {
+		t.Errorf("/images/logo.webp redirects to %s, but an SVG was never converted", to)
+	}
+}
+
+func TestBuildRejectsBadRedirects(t *testing.T) {
+	tests := []struct {
+		rules string
+		want  string // in the error, or "" for none
+	}{
+		{"/old/* /articles/:splat\n", ""},
+		{"/articles/first-post /elsewhere/\n", "/articles/first-post would redirect /articles/first-post/"},
+		{"/tag/* /topics/:splat\n", "/tag/* would redirect /tag/"},
+		{"/feed.xml /all/feed.xml\n", "/feed.xml would redirect /feed.xml"},
+		{"/loop /loop/\n", "would loop"},
+	}
+	for _, tt := range tests {
+		b := testBuilder(filepath.Join(t.TempDir(), "public"), "")
+		b.Content.(fstest.MapFS)["redirects"] = &fstest.MapFile{Data: []byte(tt.rules)}
+		_, err := b.Build()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("redirects %q: %v", tt.rules, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("redirects %q: error %v, want one containing %q", tt.rules, err, tt.want)
+		}
+	}
+}
+
+// Feed readers take two Atom feeds with the same id for one feed.
+func TestBuildAtomFeedIDs(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "public")
+	if _, err := testBuilder(out, "").Build(); err != nil {
+		t.Fatal(err)
+	}
+	var feeds []string
+	err := filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Name() == "atom.xml" {
+			feeds = append(feeds, p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 5 {
+		t.Errorf("found %d atom.xml files, want 5 (posts, notes, all and two tags)", len(feeds))
+	}
+	seen := map[string]string{}
+	for _, name := range feeds {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var feed struct {
+			ID string `xml:"id"`
+		}
+		if err := xml.Unmarshal(b, &feed); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		rel, _ := filepath.Rel(out, name)
+		if feed.ID == "" {
+			t.Errorf("%s has no id", rel)
+		} else if other, ok := seen[feed.ID]; ok {
+			t.Errorf("%s and %s share the id %s", other, rel, feed.ID)
+		}
+		seen[feed.ID] = rel
+	}
+}
+
+// rebuild builds b after marking every existing output as old, and
+// returns the outputs the build wrote, precompressed copies left out.
+func rebuild(t *testing.T, b *Builder) []string {
+	t.Helper()
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	dirs := []string{b.OutDir, DefaultServerDir(b.OutDir)}
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				err = os.Chtimes(p, old, old)
+			}
+			return err
+		})
+	}
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	var written []string
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || strings.HasSuffix(p, ".gz") || strings.HasSuffix(p, ".br") {
+				return err
+			}
+			fi, err := d.Info()
+			if err == nil && !fi.ModTime().Equal(old) {
+				rel, _ := filepath.Rel(b.OutDir, p)
+				written = append(written, filepath.ToSlash(rel))
+			}
+			return err
+		})
+	}
+	sort.Strings(written)
+	return written
+}
+
+// outputs lists every output of the build in outDir, as rebuild does.
+func outputs(t *testing.T, outDir string) []string {
+	t.Helper()
+	var all []string
+	for _, dir := range []string{outDir, DefaultServerDir(outDir)} {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && !strings.HasSuffix(p, ".gz") && !strings.HasSuffix(p, ".br") {
+				rel, _ := filepath.Rel(outDir, p)
+				all = append(all, filepath.ToSlash(rel))
+			}
+			return err
+		})
+	}
+	sort.Strings(all)
+	return all
+}
+
+func TestBuildInvalidation(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "public")
+	cache := filepath.Join(dir, "cache")
+	b := testBuilder(out, cache)
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	all := outputs(t, out)
+
+	same := func(got, want []string) bool { return strings.Join(got, "\n") == strings.Join(want, "\n") }
+
+	if got := rebuild(t, b); len(got) != 0 {
+		t.Errorf("rebuilding unchanged input wrote %q", got)
+	}
+
+	// Editing one note's body rewrites its page and everything that
+	// includes its content, and nothing else.
+	b.Content.(fstest.MapFS)["notes/other.md"] = &fstest.MapFile{Data: []byte(strings.Replace(testContent["notes/other.md"], "Short.", "Shorter.", 1))}
+	want := []string{"all/atom.xml", "all/feed.json", "all/feed.xml", "notes/atom.xml", "notes/feed.json", "notes/feed.xml", "notes/other/index.html", "search/index.json"}
+	if got := rebuild(t, b); !same(got, want) {
+		t.Errorf("after editing a note, wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Neighbouring articles link to each other by title, so editing an
+	// article's body leaves them alone.
+	b.Content.(fstest.MapFS)["articles/first.md"] = &fstest.MapFile{Data: []byte(strings.Replace(testContent["articles/first.md"], "Hello", "Hi", 1))}
+	want = []string{
+		"all/atom.xml", "all/feed.json", "all/feed.xml", "articles/first-post/index.html", "atom.xml", "feed.json", "feed.xml", "search/index.json",
+		"tag/go/atom.xml", "tag/go/feed.json", "tag/go/feed.xml", "tag/meta/atom.xml", "tag/meta/feed.json", "tag/meta/feed.xml",
+	}
+	if got := rebuild(t, b); !same(got, want) {
+		t.Errorf("after editing an article, wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A template change rewrites every page, and so does a change to the
+	// site's settings.
+	b.Templates.(fstest.MapFS)["404.html.tmpl"] = &fstest.MapFile{Data: []byte("<!doctype html><title>Gone</title>\n")}
+	if got := rebuild(t, b); !same(got, all) {
+		t.Errorf("after a template change, wrote\n%s\nwant everything:\n%s", strings.Join(got, "\n"), strings.Join(all, "\n"))
+	}
+	b.Config.Description = "Still a site for tests"
+	if got := rebuild(t, b); !same(got, all) {
+		t.Errorf("after a site.env change, wrote\n%s\nwant everything:\n%s", strings.Join(got, "\n"), strings.Join(all, "\n"))
+	}
+
+	// A stylesheet change publishes it under a new name, which every page
+	// refers to, and removes the old one.
+	b.Static["css"] = mapFS(map[string]string{"site.css": "body { color: navy; }\n"})
+	got := rebuild(t, b)
+	now := outputs(t, out)
+	if !same(got, now) {
+		t.Errorf("after a stylesheet change, wrote\n%s\nwant everything:\n%s", strings.Join(got, "\n"), strings.Join(now, "\n"))
+	}
+	var oldCSS, newCSS []string
+	for _, p := range all {
+		if strings.HasPrefix(p, "css/") {
+			oldCSS = append(oldCSS, p)
+		}
+	}
+	for _, p := range now {
+		if strings.HasPrefix(p, "css/") {
+			newCSS = append(newCSS, p)
+		}
+	}
+	if len(oldCSS) != 1 || len(newCSS) != 1 || oldCSS[0] == newCSS[0] {
+		t.Errorf("stylesheets before %q and after %q, want one each under different names", oldCSS, newCSS)
+	}
+}
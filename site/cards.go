@@ -0,0 +1,244 @@
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Social cards are the og:image of notes and of articles without a hero.
+const (
+	cardWidth  = 1200
+	cardHeight = 630
+	cardMargin = 72
+
+	// cardVersion is part of each card's cache key; bump it when the
+	// layout changes so every card is drawn again.
+	cardVersion = "1"
+)
+
+// Colors of the retro theme, from the stylesheet.
+var (
+	cardTop    = color.RGBA{0x0b, 0x10, 0x30, 0xff}
+	cardBottom = color.RGBA{0x0a, 0x0e, 0x1a, 0xff}
+	cardFrame  = color.RGBA{0x20, 0x28, 0x4a, 0xff}
+	cardFG     = color.RGBA{0xd7, 0xea, 0xff, 0xff}
+	cardMuted  = color.RGBA{0x9f, 0xb1, 0xd6, 0xff}
+	cardCyan   = color.RGBA{0x00, 0xff, 0xf0, 0xff}
+	cardMag    = color.RGBA{0xff, 0x34, 0xd2, 0xff}
+)
+
+// cardFonts are the embedded Go Mono faces, parsed once.
+var cardFonts = sync.OnceValues(func() ([2]*opentype.Font, error) {
+	var fonts [2]*opentype.Font
+	for i, ttf := range [][]byte{gomono.TTF, gomonobold.TTF} {
+		f, err := opentype.Parse(ttf)
+		if err != nil {
+			return fonts, err
+		}
+		fonts[i] = f
+	}
+	return fonts, nil
+})
+
+// card is what's drawn on one social card.
+type card struct {
+	Version string
+	Site    string
+	Title   string
+	Date    string
+	Tags    []string
+}
+
+func newCard(site Config, title, date string, tags []Tag) card {
+	c := card{Version: cardVersion, Site: site.Name, Title: title, Date: date}
+	for _, tg := range tags {
+		c.Tags = append(c.Tags, "#"+tg.Slug)
+	}
+	return c
+}
+
+// cardPage queues writing card c to the output path for url, if there is
+// one.
+func (r *run) cardPage(url string, c card) {
+	if url == "" {
+		return
+	}
+	outPath := filepath.Join(r.OutDir, filepath.FromSlash(strings.TrimPrefix(url, "/")))
+	r.page(func() error {
+		if err := r.writeCard(outPath, c); err != nil {
+			return fmt.Errorf("render card %s: %w", url, err)
+		}
+		return nil
+	})
+}
+
+// writeCard writes the card to outPath. Drawn cards are kept in
+// CacheDir/cards by the hash of what's on them, so a card is only drawn
+// again when its title, date, tags or the site name change, not on every
+// full build.
+func (r *run) writeCard(outPath string, c card) error {
+	return r.cache.writePage(outPath, c, func(buf *bytes.Buffer) error {
+		var cached string
+		if r.CacheDir != "" {
+			key, err := hashJSON(c)
+			if err != nil {
+				return err
+			}
+			cached = filepath.Join(r.CacheDir, "cards", key+".png")
+			if b, err := os.ReadFile(cached); err == nil {
+				_, err = buf.Write(b)
+				return err
+			}
+		}
+		img, err := c.draw()
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(buf, img); err != nil {
+			return err
+		}
+		if cached == "" {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(cached), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(cached, buf.Bytes(), 0o644)
+	})
+}
+
+// draw renders the card: the site name over a magenta rule, the title
+// wrapped to at most four lines, and the date and tags at the bottom, on
+// the dark gradient and scanlines of the site's CRT panel.
+func (c card) draw() (image.Image, error) {
+	fonts, err := cardFonts()
+	if err != nil {
+		return nil, err
+	}
+	regular, bold := fonts[0], fonts[1]
+
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	for y := range cardHeight {
+		t := float64(y) / cardHeight
+		row := color.RGBA{
+			lerp(cardTop.R, cardBottom.R, t),
+			lerp(cardTop.G, cardBottom.G, t),
+			lerp(cardTop.B, cardBottom.B, t),
+			0xff,
+		}
+		if y%4 == 3 {
+			row.R, row.G, row.B = row.R*7/8, row.G*7/8, row.B*7/8
+		}
+		draw.Draw(img, image.Rect(0, y, cardWidth, y+1), image.NewUniform(row), image.Point{}, draw.Src)
+	}
+	frame := image.Rect(24, 24, cardWidth-24, cardHeight-24)
+	for _, r := range []image.Rectangle{
+		{frame.Min, image.Pt(frame.Max.X, frame.Min.Y+2)},
+		{image.Pt(frame.Min.X, frame.Max.Y-2), frame.Max},
+		{frame.Min, image.Pt(frame.Min.X+2, frame.Max.Y)},
+		{image.Pt(frame.Max.X-2, frame.Min.Y), frame.Max},
+	} {
+		draw.Draw(img, r, image.NewUniform(cardFrame), image.Point{}, draw.Src)
+	}
+
+	width := cardWidth - 2*cardMargin
+	siteFace, err := opentype.NewFace(bold, &opentype.FaceOptions{Size: 32, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	drawText(img, siteFace, cardCyan, cardMargin, 112, fit(siteFace, c.Site, width))
+	draw.Draw(img, image.Rect(cardMargin, 136, cardWidth-cardMargin, 140), image.NewUniform(cardMag), image.Point{}, draw.Src)
+
+	// Use the largest size the title fits at, shortening it at the
+	// smallest if it has to.
+	var lines []string
+	var titleFace font.Face
+	var size float64
+	for _, size = range []float64{64, 54, 46} {
+		titleFace, err = opentype.NewFace(bold, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		if lines = wrap(titleFace, c.Title, width); len(lines) <= 4 {
+			break
+		}
+	}
+	if len(lines) > 4 {
+		lines = lines[:4]
+		lines[3] = fit(titleFace, lines[3]+" …", width)
+	}
+	y := 220 + int(size)
+	for _, line := range lines {
+		drawText(img, titleFace, cardFG, cardMargin, y, fit(titleFace, line, width))
+		y += int(size * 1.25)
+	}
+
+	metaFace, err := opentype.NewFace(regular, &opentype.FaceOptions{Size: 28, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	x := drawText(img, metaFace, cardMuted, cardMargin, cardHeight-72, c.Date)
+	if len(c.Tags) > 0 {
+		x = drawText(img, metaFace, cardMuted, x, cardHeight-72, " · ")
+		drawText(img, metaFace, cardCyan, x, cardHeight-72, fit(metaFace, strings.Join(c.Tags, " "), cardWidth-cardMargin-x))
+	}
+	return img, nil
+}
+
+// drawText draws s with its baseline at y and returns where it ends.
+func drawText(dst draw.Image, face font.Face, col color.Color, x, y int, s string) int {
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(col), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(s)
+	return d.Dot.X.Round()
+}
+
+// wrap breaks s into lines no wider than width, at spaces.
+func wrap(face font.Face, s string, width int) []string {
+	var lines []string
+	line := ""
+	for _, w := range strings.Fields(s) {
+		next := w
+		if line != "" {
+			next = line + " " + w
+		}
+		if line != "" && font.MeasureString(face, next).Round() > width {
+			lines = append(lines, line)
+			next = w
+		}
+		line = next
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fit shortens s with an ellipsis until it's no wider than width.
+func fit(face font.Face, s string, width int) string {
+	if font.MeasureString(face, s).Round() <= width {
+		return s
+	}
+	r := []rune(strings.TrimSuffix(s, " …"))
+	for len(r) > 0 && font.MeasureString(face, string(r)+"…").Round() > width {
+		r = r[:len(r)-1]
+	}
+	return strings.TrimRight(string(r), " ") + "…"
+}
+
+func lerp(a, b uint8, t float64) uint8 {
+	return uint8(float64(a) + (float64(b)-float64(a))*t)
+}
@@ -0,0 +1,55 @@
+package site
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressedSuffixes are the extensions of the precompressed copies
+// written beside each compressible output, for servers to send as they
+// are instead of compressing on every request.
+var compressedSuffixes = []string{".gz", ".br"}
+
+// compressible reports whether the output at p is text worth compressing.
+func compressible(p string) bool {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".html", ".css", ".js", ".json", ".xml", ".svg", ".txt", ".webmanifest":
+		return true
+	}
+	return false
+}
+
+// writeCompressed writes b to outPath.gz and outPath.br at the highest
+// compression levels, which are too slow to use per request but cost
+// little once per build.
+func writeCompressed(outPath string, b []byte) error {
+	var gz bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath+".gz", gz.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+	if err := bw.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(outPath+".br", br.Bytes(), 0o644)
+}
@@ -0,0 +1,112 @@
+package site
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Config holds all site-specific configuration
+type Config struct {
+	URL               string
+	Name              string
+	Description       string
+	AuthorName        string
+	AuthorEmail       string
+	AuthorPhoto       string
+	AuthorFediverse   string
+	AuthorMastodonURL string
+	WebmentionDomain  string
+	DefaultOGImage    string
+}
+
+// configKeys maps each site.env key to its Config field, in the order
+// String writes them.
+var configKeys = []struct {
+	key   string
+	field func(*Config) *string
+}{
+	{"SITE_URL", func(c *Config) *string { return &c.URL }},
+	{"SITE_NAME", func(c *Config) *string { return &c.Name }},
+	{"SITE_DESCRIPTION", func(c *Config) *string { return &c.Description }},
+	{"AUTHOR_NAME", func(c *Config) *string { return &c.AuthorName }},
+	{"AUTHOR_EMAIL", func(c *Config) *string { return &c.AuthorEmail }},
+	{"AUTHOR_PHOTO", func(c *Config) *string { return &c.AuthorPhoto }},
+	{"AUTHOR_FEDIVERSE", func(c *Config) *string { return &c.AuthorFediverse }},
+	{"AUTHOR_MASTODON_URL", func(c *Config) *string { return &c.AuthorMastodonURL }},
+	{"WEBMENTION_DOMAIN", func(c *Config) *string { return &c.WebmentionDomain }},
+	{"DEFAULT_OG_IMAGE", func(c *Config) *string { return &c.DefaultOGImage }},
+}
+
+// String formats c as a site.env file, leaving out empty settings.
+func (c Config) String() string {
+	var b strings.Builder
+	for _, k := range configKeys {
+		if v := *k.field(&c); v != "" {
+			fmt.Fprintf(&b, "%s=\"%s\"\n", k.key, v)
+		}
+	}
+	return b.String()
+}
+
+// LoadConfig reads and validates the site.env file at path.
+func LoadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("open site config %s: %w", path, err)
+	}
+	defer f.Close()
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return Config{}, fmt.Errorf("read site config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig reads KEY=value lines in the site.env format and checks that
+// the required keys are present.
+func ParseConfig(r io.Reader) (Config, error) {
+	cfg := Config{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		val := strings.TrimSpace(parts[1])
+		// Remove one pair of surrounding quotes
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+
+		for _, k := range configKeys {
+			if k.key == key {
+				*k.field(&cfg) = val
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Config{}, err
+	}
+
+	// Validate required fields
+	var errs []error
+	if cfg.URL == "" {
+		errs = append(errs, errors.New("SITE_URL is required in site.env"))
+	}
+	if cfg.Name == "" {
+		errs = append(errs, errors.New("SITE_NAME is required in site.env"))
+	}
+	if cfg.AuthorName == "" {
+		errs = append(errs, errors.New("AUTHOR_NAME is required in site.env"))
+	}
+	return cfg, errors.Join(errs...)
+}
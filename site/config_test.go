@@ -0,0 +1,23 @@
+package site
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigStringRoundTrip(t *testing.T) {
+	cfg := Config{
+		URL:               "https://example.com",
+		Name:              `Jon's "Blog"`,
+		Description:       "Notes = thoughts",
+		AuthorName:        "Jon",
+		AuthorMastodonURL: "https://mastodon.social/@jon",
+	}
+	got, err := ParseConfig(strings.NewReader(cfg.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != cfg {
+		t.Errorf("ParseConfig(%q) = %+v, want %+v", cfg.String(), got, cfg)
+	}
+}
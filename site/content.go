@@ -0,0 +1,436 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+type Author struct {
+	Name string  `json:"name"`
+	URL  *string `json:"url"`
+}
+type Tag struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+type Hero struct {
+	Src string `json:"src"`
+	Alt string `json:"alt"`
+
+	// Set by the build from the published image.
+	Width  int    `json:"-" yaml:"-"`
+	Height int    `json:"-" yaml:"-"`
+	Srcset string `json:"-" yaml:"-"`
+	Sizes  string `json:"-" yaml:"-"`
+}
+type Article struct {
+	Slug           string   `json:"slug"`
+	Title          string   `json:"title"`
+	Subtitle       *string  `json:"subtitle"`
+	Date           string   `json:"date"` // YYYY-MM-DD
+	Updated        *string  `json:"updated"`
+	Author         Author   `json:"author"`
+	Summary        *string  `json:"summary"`
+	Tags           []Tag    `json:"tags"`
+	Hero           *Hero    `json:"hero"`
+	CanonicalURL   *string  `json:"canonical_url"`
+	CSS            *string  `json:"css"`
+	Draft          bool     `json:"draft"`
+	ReadingTimeMin *int     `json:"reading_time_min"`
+	TOC            bool     `json:"toc"`     // show a table of contents
+	Aliases        []string `json:"aliases"` // earlier slugs or URL paths that redirect here
+	ContentHTML    string   `json:"content_html"`
+	// derived
+	t    time.Time
+	src  *frontMatter
+	Prev *Article `json:"-"`
+	Next *Article `json:"-"`
+}
+
+type markdownArticle struct {
+	Slug           string   `yaml:"slug"`
+	Title          string   `yaml:"title"`
+	Subtitle       *string  `yaml:"subtitle"`
+	Date           string   `yaml:"date"`
+	Updated        *string  `yaml:"updated"`
+	Author         Author   `yaml:"author"`
+	Summary        *string  `yaml:"summary"`
+	Tags           []Tag    `yaml:"tags"`
+	Hero           *Hero    `yaml:"hero"`
+	CanonicalURL   *string  `yaml:"canonical_url"`
+	CSS            *string  `yaml:"css"`
+	Draft          bool     `yaml:"draft"`
+	ReadingTimeMin *int     `yaml:"reading_time_min"`
+	TOC            bool     `yaml:"toc"`
+	Aliases        []string `yaml:"aliases"`
+}
+
+// Note represents a short public note (like a gist)
+type Note struct {
+	Slug        string  `yaml:"slug"`
+	Title       string  `yaml:"title"`
+	Date        string  `yaml:"date"` // YYYY-MM-DD or YYYY-MM-DDTHH:MM
+	Author      Author  `yaml:"author"`
+	Tags        []Tag   `yaml:"tags"`
+	Source      *string `yaml:"source"` // optional: URL, book name, or person
+	Draft       bool    `yaml:"draft"`
+	ContentHTML string  `yaml:"-"`
+	t           time.Time
+	src         *frontMatter
+}
+
+// Queued is an article or note held back because its date is still in
+// the future.
+type Queued struct {
+	URL   string
+	Title string
+	At    time.Time
+}
+
+var (
+	reScriptStyle = regexp.MustCompile(`(?is)<script[^>]*>.*?</script>|<style[^>]*>.*?</style>`)
+	reTags        = regexp.MustCompile(`(?s)<[^>]+>`)
+	reSpace       = regexp.MustCompile(`\s+`)
+)
+
+// loaded is the outcome of reading one content file. ok is false when the
+// file couldn't be decoded at all or is a draft that isn't being built;
+// errs can be set either way.
+type loaded[T any] struct {
+	v    T
+	ok   bool
+	errs []error
+}
+
+// contentFiles lists the files below dir in the content FS that have one
+// of the given extensions, in lexical order.
+func (r *run) contentFiles(dir string, exts ...string) []string {
+	var paths []string
+	err := fs.WalkDir(r.Content, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			r.problems = append(r.problems, err)
+			return nil
+		}
+		if !d.IsDir() && slices.Contains(exts, filepath.Ext(path)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		r.problems = append(r.problems, err)
+	}
+	return paths
+}
+
+// loadArticles reads every .json and .md file below articles/ in the
+// content FS, routing drafts and future-dated articles aside. Files are
+// parsed in parallel, then merged in path order. Problems are collected
+// in r.problems rather than stopping the load.
+func (r *run) loadArticles() {
+	paths := r.contentFiles("articles", ".json", ".md")
+	results := make([]loaded[Article], len(paths))
+	r.parallel(len(paths), func(i int) error {
+		results[i] = r.readArticle(paths[i])
+		return nil
+	})
+	for _, res := range results {
+		r.problems = append(r.problems, res.errs...)
+		if !res.ok {
+			continue
+		}
+		a := res.v
+		if !a.Draft && a.t.After(r.now) {
+			r.queued = append(r.queued, Queued{URL: "/articles/" + a.Slug + "/", Title: a.Title, At: a.t})
+			if !r.Drafts {
+				r.heldArts = append(r.heldArts, a)
+				continue
+			}
+			a.Draft = true
+		}
+		if a.Draft {
+			r.draftArts = append(r.draftArts, a)
+			continue
+		}
+		r.arts = append(r.arts, a)
+	}
+	sort.Slice(r.arts, func(i, j int) bool { return r.arts[i].t.After(r.arts[j].t) })
+
+	for i := range r.arts {
+		if i > 0 {
+			r.arts[i].Next = &r.arts[i-1]
+		}
+		if i < len(r.arts)-1 {
+			r.arts[i].Prev = &r.arts[i+1]
+		}
+	}
+}
+
+// readArticle reads one article. Problems with its fields are collected
+// rather than returned at once, and the article is still loaded with
+// whatever did decode, so validate can report the rest of its problems
+// too. Any problem fails the build before anything is rendered.
+func (r *run) readArticle(path string) loaded[Article] {
+	fail := func(errs ...error) loaded[Article] { return loaded[Article]{errs: errs} }
+	b, err := fs.ReadFile(r.Content, path)
+	if err != nil {
+		return fail(err)
+	}
+	var a Article
+	var errs []error
+	if strings.HasSuffix(path, ".json") {
+		// The decoder carries on past unknown keys and values of the wrong
+		// type, reporting only the first; only a syntax error stops it.
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&a); err != nil {
+			var se *json.SyntaxError
+			if errors.As(err, &se) {
+				return fail(jsonError(path, b, err))
+			}
+			errs = append(errs, jsonError(path, b, err))
+		}
+		if a.Draft && !r.Drafts {
+			return loaded[Article]{errs: errs}
+		}
+		a.src = jsonSource(path, b)
+		if a.t, err = parseDate(a.Date); err != nil {
+			errs = append(errs, a.src.errorf("date", "%v", err))
+		}
+	} else {
+		fm, body, ok := splitFrontMatter(path, b)
+		if !ok {
+			return fail(&FileError{Path: path, Line: 1, Err: errMissingFrontMatter})
+		}
+		var meta markdownArticle
+		errs, ok = fm.decode(&meta)
+		if !ok {
+			return fail(errs...)
+		}
+		if meta.Draft && !r.Drafts {
+			return loaded[Article]{errs: errs}
+		}
+		t, err := parseDate(meta.Date)
+		if err != nil {
+			errs = append(errs, fm.errorf("date", "%v", err))
+		}
+		htmlStr, renderErrs := r.renderMarkdown(path, body, bodyLine(b, body))
+		errs = append(errs, renderErrs...)
+		a = Article{
+			Slug:           meta.Slug,
+			Title:          meta.Title,
+			Subtitle:       meta.Subtitle,
+			Date:           meta.Date,
+			Updated:        meta.Updated,
+			Author:         meta.Author,
+			Summary:        meta.Summary,
+			Tags:           meta.Tags,
+			Hero:           meta.Hero,
+			CanonicalURL:   meta.CanonicalURL,
+			CSS:            meta.CSS,
+			Draft:          meta.Draft,
+			ReadingTimeMin: meta.ReadingTimeMin,
+			TOC:            meta.TOC,
+			Aliases:        meta.Aliases,
+			ContentHTML:    htmlStr,
+			t:              t,
+			src:            fm,
+		}
+	}
+	if a.ReadingTimeMin == nil || *a.ReadingTimeMin < 1 {
+		rt := readingTimeMinutes(a.ContentHTML)
+		a.ReadingTimeMin = &rt
+	}
+	return loaded[Article]{v: a, ok: true, errs: errs}
+}
+
+// loadNotes reads every .md file below notes/ in the content FS, the same
+// way loadArticles does. The directory is optional.
+func (r *run) loadNotes() {
+	if fi, err := fs.Stat(r.Content, "notes"); err != nil || !fi.IsDir() {
+		return
+	}
+	paths := r.contentFiles("notes", ".md")
+	results := make([]loaded[Note], len(paths))
+	r.parallel(len(paths), func(i int) error {
+		results[i] = r.readNote(paths[i])
+		return nil
+	})
+	for _, res := range results {
+		r.problems = append(r.problems, res.errs...)
+		if !res.ok {
+			continue
+		}
+		note := res.v
+		if !note.Draft && note.t.After(r.now) {
+			r.queued = append(r.queued, Queued{URL: "/notes/" + note.Slug + "/", Title: note.Title, At: note.t})
+			if !r.Drafts {
+				r.heldNotes = append(r.heldNotes, note)
+				continue
+			}
+			note.Draft = true
+		}
+		if note.Draft {
+			r.draftNotes = append(r.draftNotes, note)
+			continue
+		}
+		r.notes = append(r.notes, note)
+	}
+	sort.Slice(r.notes, func(i, j int) bool { return r.notes[i].t.After(r.notes[j].t) })
+}
+
+// readNote reads one note, collecting problems the way readArticle does.
+func (r *run) readNote(path string) loaded[Note] {
+	fail := func(errs ...error) loaded[Note] { return loaded[Note]{errs: errs} }
+	b, err := fs.ReadFile(r.Content, path)
+	if err != nil {
+		return fail(err)
+	}
+	fm, body, ok := splitFrontMatter(path, b)
+	if !ok {
+		return fail(&FileError{Path: path, Line: 1, Err: errMissingFrontMatter})
+	}
+	var note Note
+	errs, ok := fm.decode(&note)
+	if !ok {
+		return fail(errs...)
+	}
+	if note.Draft && !r.Drafts {
+		return loaded[Note]{errs: errs}
+	}
+	note.src = fm
+	if note.t, err = parseDateTime(note.Date); err != nil {
+		errs = append(errs, fm.errorf("date", "%v", err))
+	}
+	var renderErrs []error
+	note.ContentHTML, renderErrs = r.renderMarkdown(path, body, bodyLine(b, body))
+	errs = append(errs, renderErrs...)
+	return loaded[Note]{v: note, ok: true, errs: errs}
+}
+
+var errMissingFrontMatter = errors.New("missing front matter: expected a block between --- lines")
+
+// markdown is shared by every conversion; goldmark is safe for concurrent
+// use once configured.
+//
+// Fenced code is highlighted at build time into spans with Chroma's short
+// class names (.k, .s, .c, ...), which the stylesheet colors for both
+// themes. The info string takes options after the language:
+//
+//	```go {linenos=true hl_lines=[2,"4-5"] linenostart=10}
+//
+// Headings get ids derived from their text and a permalink anchor.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Strikethrough,
+		extension.Table,
+		extension.TaskList,
+		extension.Footnote,
+		highlighting.NewHighlighting(
+			highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+		),
+	),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(headingAnchors{}, 1000)),
+	),
+	goldmark.WithRendererOptions(
+		html.WithUnsafe(),
+		html.WithXHTML(),
+	),
+)
+
+// bodyLine is the file line the body of file b starts on.
+func bodyLine(b, body []byte) int {
+	return 1 + bytes.Count(b[:len(b)-len(body)], []byte("\n"))
+}
+
+func convertMarkdown(src []byte) (string, error) {
+	htmlBuf := new(bytes.Buffer)
+	if err := markdown.Convert(src, htmlBuf); err != nil {
+		return "", err
+	}
+	return htmlBuf.String(), nil
+}
+
+// plainText strips scripts, styles and tags from contentHTML and collapses
+// whitespace. Entities are left encoded.
+func plainText(contentHTML string) string {
+	t := reScriptStyle.ReplaceAllString(contentHTML, "")
+	t = reTags.ReplaceAllString(t, "")
+	return strings.TrimSpace(reSpace.ReplaceAllString(t, " "))
+}
+
+func readingTimeMinutes(contentHTML string) int {
+	t := plainText(contentHTML)
+	if t == "" {
+		return 1
+	}
+	words := len(strings.Fields(t))
+	mins := (words + 219) / 220 // ceil
+	if mins < 1 {
+		mins = 1
+	}
+	return mins
+}
+
+func parseDate(s string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date %q: %w", s, err)
+	}
+	return t, nil
+}
+
+func parseDateTime(s string) (time.Time, error) {
+	// Try datetime format first (YYYY-MM-DDTHH:MM)
+	t, err := time.Parse("2006-01-02T15:04", s)
+	if err == nil {
+		return t, nil
+	}
+	// Fall back to date-only format (YYYY-MM-DD)
+	t, err = time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date/datetime %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// articleModified is the article's updated date when set, else its date.
+func articleModified(a Article) time.Time {
+	if a.Updated != nil {
+		if t, err := time.Parse("2006-01-02", *a.Updated); err == nil && t.After(a.t) {
+			return t
+		}
+	}
+	return a.t
+}
+
+func humanDate(t time.Time) string {
+	return t.Format("January 2, 2006")
+}
+
+func humanMonth(key string) string {
+	// key "YYYY/MM"
+	t, err := time.Parse("2006/01", key)
+	if err != nil {
+		return key
+	}
+	return t.Format("January 2006")
+}
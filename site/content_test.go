@@ -0,0 +1,130 @@
+package site
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// loadRun loads and validates content without templates, as load does.
+func loadRun(t *testing.T, content fstest.MapFS) *run {
+	t.Helper()
+	r := &run{
+		Builder: &Builder{Content: content},
+		now:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		log:     log.New(io.Discard, "", 0),
+	}
+	r.loadArticles()
+	r.loadNotes()
+	r.validate()
+	return r
+}
+
+// wantProblems checks that r found exactly the problems in want, each
+// given as "path:line: message".
+func wantProblems(t *testing.T, r *run, want ...string) {
+	t.Helper()
+	var got []string
+	for _, p := range r.problems {
+		got = append(got, p.Error())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReadCollectsFieldProblems(t *testing.T) {
+	r := loadRun(t, fstest.MapFS{
+		"articles/a.md": {Data: []byte(`---
+slug: a
+title: A
+date: 2025-01-01
+tags:
+  - name: Go
+    slug: go
+---
+body
+`)},
+		"articles/b.md": {Data: []byte(`---
+slug: b
+date: 2025-13-01
+colour: red
+tags:
+  - name: golang
+    slug: go
+---
+body
+`)},
+		"articles/c.json": {Data: []byte(`{
+  "slug": "c",
+  "title": "C",
+  "date": "someday",
+  "extra": true,
+  "tags": [{"name": "Golang", "slug": "go"}]
+}`)},
+		"notes/n.md": {Data: []byte(`---
+slug: n
+title: [not, a, string]
+date: 2025-01-01
+tags:
+  - name: GO
+    slug: go
+---
+body
+`)},
+	})
+	wantProblems(t, r,
+		`articles/b.md:4: field colour not found in type site.markdownArticle`,
+		`articles/b.md:3: bad date "2025-13-01": parsing time "2025-13-01": month out of range`,
+		`articles/c.json: json: unknown field "extra"`,
+		`articles/c.json:4: bad date "someday": parsing time "someday" as "2006-01-02": cannot parse "someday" as "2006"`,
+		`notes/n.md:3: cannot unmarshal !!seq into string`,
+		`articles/b.md:1: title is required`,
+		`articles/b.md:6: tag "go" is named "golang" here but "Go" in articles/a.md:6`,
+		`articles/c.json:6: tag "go" is named "Golang" here but "Go" in articles/a.md:6`,
+		`notes/n.md:3: title is required`,
+		`notes/n.md:6: tag "go" is named "GO" here but "Go" in articles/a.md:6`,
+	)
+}
+
+func TestReadStopsOnUnparsableFrontMatter(t *testing.T) {
+	r := loadRun(t, fstest.MapFS{
+		"articles/a.md":   {Data: []byte("---\nslug: [a\n---\nbody\n")},
+		"articles/b.json": {Data: []byte(`{"slug": "b",}`)},
+	})
+	if len(r.arts) != 0 {
+		t.Errorf("loaded %d articles, want none", len(r.arts))
+	}
+	var fe *FileError
+	if len(r.problems) != 2 || !errors.As(r.problems[0], &fe) || fe.Path != "articles/a.md" {
+		t.Errorf("problems = %v, want one for each file", r.problems)
+	}
+}
+
+func TestValidateScheduledAndDuplicateSlugs(t *testing.T) {
+	article := func(slug, date string, extra string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte("---\nslug: " + slug + "\ntitle: T\ndate: " + date + "\n" + extra + "---\nbody\n")}
+	}
+	r := loadRun(t, fstest.MapFS{
+		// a is newer but sorts first, so it's blamed for b's slug.
+		"articles/a.md": article("same", "2025-06-01", ""),
+		"articles/b.md": article("same", "2025-01-01", ""),
+		// c is a draft that isn't built; d is scheduled, so it's checked
+		// though it isn't rendered, and blamed though it sorts first.
+		"articles/c.md": article("other", "2025-01-01", "draft: true\n"),
+		"articles/d.md": article("old", "2026-06-01", "tags:\n  - name: X\n"),
+		"articles/e.md": article("old", "2024-01-01", ""),
+	})
+	wantProblems(t, r,
+		`articles/a.md:2: duplicate slug "same", also used by articles/b.md:2`,
+		`articles/d.md:6: tag needs both a name and a slug`,
+		`articles/d.md:2: duplicate slug "old", also used by articles/e.md:2`,
+	)
+	if len(r.arts) != 3 || len(r.heldArts) != 1 {
+		t.Errorf("got %d published and %d held articles, want 3 and 1", len(r.arts), len(r.heldArts))
+	}
+}
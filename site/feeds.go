@@ -0,0 +1,371 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// feedEntry is the format-neutral form of an article or note, from which
+// the RSS, Atom and JSON Feed items are built.
+type feedEntry struct {
+	Type        string // "article" or "note"
+	Title       string
+	URL         string // absolute
+	ContentHTML string
+	Summary     string
+	Author      Author
+	Tags        []Tag
+	Image       string // absolute URL of the hero image, if any
+	Published   time.Time
+	Updated     time.Time
+}
+
+// feedInfo describes one feed. Dir is the URL path the feed files live
+// under, e.g. "/" or "/notes/".
+type feedInfo struct {
+	Title       string
+	Link        string
+	Description string
+	Dir         string
+}
+
+func articleFeedEntry(site Config, a Article, img imageSet) feedEntry {
+	e := feedEntry{
+		Type:        "article",
+		Title:       a.Title,
+		URL:         site.URL + "/articles/" + a.Slug + "/",
+		ContentHTML: img.rewrite(a.ContentHTML),
+		Author:      feedAuthor(site, a.Author),
+		Tags:        a.Tags,
+		Published:   a.t,
+		Updated:     articleModified(a),
+	}
+	if a.Summary != nil {
+		e.Summary = *a.Summary
+	}
+	if a.Hero != nil {
+		e.Image = site.URL + img.src(a.Hero.Src)
+	}
+	return e
+}
+
+func noteFeedEntry(site Config, n Note, img imageSet) feedEntry {
+	return feedEntry{
+		Type:        "note",
+		Title:       n.Title,
+		URL:         site.URL + "/notes/" + n.Slug + "/",
+		ContentHTML: img.rewrite(n.ContentHTML),
+		Author:      feedAuthor(site, n.Author),
+		Tags:        n.Tags,
+		Published:   n.t,
+		Updated:     n.t,
+	}
+}
+
+func feedAuthor(site Config, a Author) Author {
+	if a.Name == "" {
+		a.Name = site.AuthorName
+	}
+	return a
+}
+
+// writeFeeds writes feed.xml (RSS 2.0), atom.xml (Atom 1.0) and feed.json
+// (JSON Feed 1.1) for entries under OutDir+info.Dir.
+func (r *run) writeFeeds(info feedInfo, entries []feedEntry) error {
+	site := r.Config
+	dir := filepath.Join(r.OutDir, filepath.FromSlash(info.Dir))
+
+	var items []rssItem
+	for _, e := range entries {
+		// The entry type rides along as a category in its own domain, so
+		// readers of mixed feeds can tell articles from notes.
+		cats := []rssCategory{{Domain: site.URL + "/type", Name: e.Type}}
+		for _, tg := range e.Tags {
+			cats = append(cats, rssCategory{Name: tg.Name})
+		}
+		items = append(items, rssItem{
+			Title:       e.Title,
+			Link:        e.URL,
+			Description: e.ContentHTML,
+			PubDate:     e.Published.Format(time.RFC1123Z),
+			GUID:        e.URL,
+			Categories:  cats,
+		})
+	}
+	if err := r.writeRSSFeed(filepath.Join(dir, "feed.xml"), info.Title, info.Link, info.Description, items); err != nil {
+		return err
+	}
+	if err := r.writeAtomFeed(filepath.Join(dir, "atom.xml"), info, entries); err != nil {
+		return err
+	}
+	return r.writeJSONFeed(filepath.Join(dir, "feed.json"), info, entries)
+}
+
+// RSS feed types
+type rssChannel struct {
+	XMLName       xml.Name  `xml:"channel"`
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description"`
+	PubDate     string        `xml:"pubDate"`
+	GUID        string        `xml:"guid"`
+	Categories  []rssCategory `xml:"category"`
+}
+
+type rssCategory struct {
+	Domain string `xml:"domain,attr,omitempty"`
+	Name   string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+func (r *run) writeRSSFeed(outPath, title, link, description string, items []rssItem) error {
+	// lastBuildDate is left out of the key so an unchanged feed isn't rewritten
+	key := []any{title, link, description, items}
+	return r.cache.writePage(outPath, key, func(buf *bytes.Buffer) error {
+		feed := rssFeed{
+			Version: "2.0",
+			Channel: rssChannel{
+				Title:         title,
+				Link:          link,
+				Description:   description,
+				Language:      "en-us",
+				LastBuildDate: r.now.Format(time.RFC1123Z),
+				Items:         items,
+			},
+		}
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(buf)
+		enc.Indent("", "  ")
+		return enc.Encode(feed)
+	})
+}
+
+// Atom feed types
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomPerson  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	URI   string `xml:"uri,omitempty"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term   string `xml:"term,attr"`
+	Scheme string `xml:"scheme,attr,omitempty"`
+	Label  string `xml:"label,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary"`
+	Content    atomText       `xml:"content"`
+}
+
+func (r *run) writeAtomFeed(outPath string, info feedInfo, entries []feedEntry) error {
+	site := r.Config
+	// Feeds may share a Link, as the posts and combined feeds do, so the
+	// ID comes from where the feed lives.
+	self := site.URL + info.Dir + "atom.xml"
+	feed := atomFeed{
+		Title:    info.Title,
+		Subtitle: info.Description,
+		ID:       self,
+		Updated:  latestUpdate(entries, r.now).Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "text/html", Href: info.Link},
+		},
+		Author: atomPerson{Name: site.AuthorName, URI: site.URL, Email: site.AuthorEmail},
+	}
+	for _, e := range entries {
+		ae := atomEntry{
+			Title:      e.Title,
+			ID:         e.URL,
+			Links:      []atomLink{{Rel: "alternate", Type: "text/html", Href: e.URL}},
+			Published:  e.Published.Format(time.RFC3339),
+			Updated:    e.Updated.Format(time.RFC3339),
+			Author:     atomPerson{Name: e.Author.Name, URI: deref(e.Author.URL)},
+			Content:    atomText{Type: "html", Body: e.ContentHTML},
+			Categories: []atomCategory{{Term: e.Type, Scheme: site.URL + "/type"}},
+		}
+		if e.Image != "" {
+			ae.Links = append(ae.Links, atomLink{Rel: "enclosure", Type: imageType(e.Image), Href: e.Image})
+		}
+		for _, tg := range e.Tags {
+			ae.Categories = append(ae.Categories, atomCategory{Term: tg.Slug, Label: tg.Name})
+		}
+		if e.Summary != "" {
+			ae.Summary = &atomText{Type: "text", Body: e.Summary}
+		}
+		feed.Entries = append(feed.Entries, ae)
+	}
+	return r.cache.writePage(outPath, feed, func(buf *bytes.Buffer) error {
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(buf)
+		enc.Indent("", "  ")
+		return enc.Encode(feed)
+	})
+}
+
+// JSON Feed types
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Language    string           `json:"language"`
+	Authors     []jsonFeedAuthor `json:"authors"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name   string `json:"name"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	Summary       string           `json:"summary,omitempty"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Site          jsonFeedSiteExt  `json:"_site"`
+}
+
+// jsonFeedSiteExt is this site's JSON Feed extension object.
+type jsonFeedSiteExt struct {
+	Type string `json:"type"` // "article" or "note"
+}
+
+func (r *run) writeJSONFeed(outPath string, info feedInfo, entries []feedEntry) error {
+	site := r.Config
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       info.Title,
+		HomePageURL: info.Link,
+		FeedURL:     site.URL + info.Dir + "feed.json",
+		Description: info.Description,
+		Language:    "en-US",
+		Authors:     []jsonFeedAuthor{{Name: site.AuthorName, URL: site.URL, Avatar: absURL(site, r.images.src(site.AuthorPhoto))}},
+		Items:       []jsonFeedItem{},
+	}
+	for _, e := range entries {
+		item := jsonFeedItem{
+			ID:            e.URL,
+			URL:           e.URL,
+			Title:         e.Title,
+			ContentHTML:   e.ContentHTML,
+			Summary:       e.Summary,
+			Image:         e.Image,
+			DatePublished: e.Published.Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: e.Author.Name, URL: deref(e.Author.URL)}},
+			Site:          jsonFeedSiteExt{Type: e.Type},
+		}
+		if e.Updated.After(e.Published) {
+			item.DateModified = e.Updated.Format(time.RFC3339)
+		}
+		for _, tg := range e.Tags {
+			item.Tags = append(item.Tags, tg.Name)
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return r.cache.writePage(outPath, feed, func(buf *bytes.Buffer) error {
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(feed)
+	})
+}
+
+// latestUpdate is the newest Updated time across entries, so an unchanged
+// feed renders identically from build to build. An empty feed uses now.
+func latestUpdate(entries []feedEntry, now time.Time) time.Time {
+	var t time.Time
+	for _, e := range entries {
+		if e.Updated.After(t) {
+			t = e.Updated
+		}
+	}
+	if t.IsZero() {
+		t = now
+	}
+	return t.UTC()
+}
+
+func imageType(src string) string {
+	switch filepath.Ext(src) {
+	case ".webp":
+		return "image/webp"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	default:
+		return "image/jpeg"
+	}
+}
+
+func absURL(site Config, p string) string {
+	if p == "" || !strings.HasPrefix(p, "/") {
+		return p
+	}
+	return site.URL + p
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
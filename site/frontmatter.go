@@ -0,0 +1,156 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileError is a problem with one content file. Line is 1-based; zero
+// means the problem isn't tied to a particular line.
+type FileError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// frontMatter is the YAML block at the top of a Markdown file, kept as a
+// node tree so problems can be reported against the line they're on.
+type frontMatter struct {
+	path   string
+	src    string
+	offset int // lines in the file before the first line of src
+	doc    yaml.Node
+}
+
+// splitFrontMatter separates a Markdown file into its front matter and
+// body. ok is false when there is no --- delimited block.
+func splitFrontMatter(path string, b []byte) (fm *frontMatter, body []byte, ok bool) {
+	parts := strings.SplitN(string(b), "---", 3)
+	if len(parts) < 3 {
+		return nil, nil, false
+	}
+	return &frontMatter{
+		path:   path,
+		src:    parts[1],
+		offset: strings.Count(parts[0], "\n"),
+	}, []byte(parts[2]), true
+}
+
+var reYAMLLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// decode decodes the front matter into v, returning one FileError per
+// problem the YAML decoder reports. Keys v has no field for are problems
+// too, since they're most likely typos. Fields with problems are skipped
+// and the rest decoded; ok is false only if the YAML doesn't parse at all
+// and v was left as it was.
+func (fm *frontMatter) decode(v any) (errs []error, ok bool) {
+	if err := yaml.Unmarshal([]byte(fm.src), &fm.doc); err != nil {
+		return []error{fm.yamlError(err.Error())}, false
+	}
+	dec := yaml.NewDecoder(strings.NewReader(fm.src))
+	dec.KnownFields(true)
+	err := dec.Decode(v)
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil, true
+	}
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return []error{fm.yamlError(err.Error())}, false
+	}
+	for _, msg := range te.Errors {
+		errs = append(errs, fm.yamlError(msg))
+	}
+	return errs, true
+}
+
+// yamlError turns a "line N: ..." message from the YAML decoder into a
+// FileError with the line counted from the top of the file.
+func (fm *frontMatter) yamlError(msg string) error {
+	if m := reYAMLLine.FindStringSubmatch(msg); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return &FileError{Path: fm.path, Line: fm.offset + n, Err: errors.New(m[2])}
+	}
+	return &FileError{Path: fm.path, Line: fm.offset + 1, Err: errors.New(strings.TrimPrefix(msg, "yaml: "))}
+}
+
+// jsonSource locates keys in a JSON article. JSON is also YAML, so the
+// same node tree serves both; if it doesn't parse, lines fall back to 1.
+func jsonSource(path string, b []byte) *frontMatter {
+	fm := &frontMatter{path: path, src: string(b)}
+	_ = yaml.Unmarshal(b, &fm.doc)
+	return fm
+}
+
+// value is the node for key in the top-level mapping, or nil.
+func (fm *frontMatter) value(key string) (k, v *yaml.Node) {
+	m := &fm.doc
+	if m.Kind == yaml.DocumentNode && len(m.Content) > 0 {
+		m = m.Content[0]
+	}
+	if m.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i], m.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// line is the file line of key in the front matter, or of the opening ---
+// when key is absent.
+func (fm *frontMatter) line(key string) int {
+	if k, _ := fm.value(key); k != nil {
+		return fm.offset + k.Line
+	}
+	return fm.offset + 1
+}
+
+// itemLine is the file line of the i'th element of the list under key,
+// falling back to the line of key itself.
+func (fm *frontMatter) itemLine(key string, i int) int {
+	if _, v := fm.value(key); v != nil && v.Kind == yaml.SequenceNode && i < len(v.Content) {
+		return fm.offset + v.Content[i].Line
+	}
+	return fm.line(key)
+}
+
+// errorf reports a problem with the value of key.
+func (fm *frontMatter) errorf(key, format string, args ...any) error {
+	return &FileError{Path: fm.path, Line: fm.line(key), Err: fmt.Errorf(format, args...)}
+}
+
+// jsonError places a JSON decoding error at the line it occurred on.
+func jsonError(path string, b []byte, err error) error {
+	var offset int64 = -1
+	var se *json.SyntaxError
+	var te *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &se):
+		offset = se.Offset
+	case errors.As(err, &te):
+		offset = te.Offset
+	}
+	if offset < 0 || offset > int64(len(b)) {
+		return &FileError{Path: path, Err: err}
+	}
+	return &FileError{Path: path, Line: 1 + bytes.Count(b[:offset], []byte("\n")), Err: err}
+}
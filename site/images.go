@@ -0,0 +1,389 @@
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// webpQuality is the lossy WebP quality, 0 to 100. It matches the cwebp
+// -q 80 the deploy script used to run.
+const webpQuality = 80
+
+// imageEncoder identifies the encoders, their settings and the scaler in
+// cache keys, so a change to any of them invalidates earlier conversions.
+var imageEncoder = fmt.Sprintf("%s-q%d,jpeg-85,catmullrom", webpEncoder, webpQuality)
+
+// imageWidths are the widths, in pixels, of the resized copies made of
+// each PNG and JPEG for srcset. Widths an image doesn't exceed are skipped.
+var imageWidths = []int{480, 960, 1440}
+
+// contentImageSizes is the sizes attribute for images in article and note
+// bodies, which are at most as wide as the .wrap column (70ch).
+const contentImageSizes = "(max-width: 720px) 100vw, 672px"
+
+// heroHeight is the height of .hero img in the stylesheet on wide screens;
+// a hero's width there follows from its aspect ratio.
+const heroHeight = 256
+
+// publishedImage is what the build published for one source image. Every
+// file carries a fingerprint of its source in its name, so it can be
+// cached for good.
+type publishedImage struct {
+	Src    string // URL of the full-size image
+	Width  int    // 0 for images that aren't PNG or JPEG
+	Height int
+	Srcset string   // the resized copies and Src, or "" if there are none
+	Files  []string `json:"-"` // URLs of every file published for the image
+}
+
+// imageSet maps the URL of each source image, such as "/images/a.png", to
+// what was published for it.
+type imageSet map[string]*publishedImage
+
+// src returns the URL to publish for the image at p.
+func (s imageSet) src(p string) string {
+	if img, ok := s[p]; ok {
+		return img.Src
+	}
+	return p
+}
+
+// hero returns h with its image as published, sized for the .hero box.
+func (s imageSet) hero(h *Hero) *Hero {
+	out := &Hero{Src: h.Src, Alt: h.Alt}
+	img, ok := s[h.Src]
+	if !ok {
+		return out
+	}
+	out.Src, out.Width, out.Height, out.Srcset = img.Src, img.Width, img.Height, img.Srcset
+	if img.Srcset != "" && img.Height > 0 {
+		out.Sizes = fmt.Sprintf("(max-width: 600px) 100vw, %dpx", heroHeight*img.Width/img.Height)
+	}
+	return out
+}
+
+var (
+	// reImageSrc matches the src of local images in rendered HTML.
+	reImageSrc  = regexp.MustCompile(`(src=["'])(/images/[^"']+)(["'])`)
+	reImageHref = regexp.MustCompile(`(href=["'])(/images/[^"']+)(["'])`)
+	reImgTag    = regexp.MustCompile(`<img\s[^>]*>`)
+	reImgAttr   = regexp.MustCompile(`\s([a-zA-Z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// rewrite points the local images in html, and links to them, at what was
+// published for them and gives each image a srcset, its intrinsic width
+// and height and lazy loading. Attributes the author set are kept.
+func (s imageSet) rewrite(html string) string {
+	html = reImageHref.ReplaceAllStringFunc(html, func(m string) string {
+		sm := reImageHref.FindStringSubmatch(m)
+		return sm[1] + s.src(sm[2]) + sm[3]
+	})
+	return reImgTag.ReplaceAllStringFunc(html, func(tag string) string {
+		sm := reImageSrc.FindStringSubmatch(tag)
+		if sm == nil {
+			return tag
+		}
+		img, ok := s[sm[2]]
+		if !ok {
+			return tag
+		}
+		tag = strings.Replace(tag, sm[0], sm[1]+img.Src+sm[3], 1)
+
+		attrs := map[string]string{}
+		for _, m := range reImgAttr.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+		}
+		w, hasW := attrs["width"]
+		_, hasH := attrs["height"]
+		width, err := strconv.Atoi(w)
+		hasW = hasW && err == nil && width > 0
+
+		var extra strings.Builder
+		if _, ok := attrs["srcset"]; !ok && img.Srcset != "" {
+			sizes := contentImageSizes
+			if hasW {
+				sizes = fmt.Sprintf("min(%dpx, 100vw)", width)
+			}
+			fmt.Fprintf(&extra, ` srcset="%s" sizes="%s"`, img.Srcset, sizes)
+		}
+		switch {
+		case !hasW && !hasH && img.Width > 0:
+			fmt.Fprintf(&extra, ` width="%d" height="%d"`, img.Width, img.Height)
+		case hasW && !hasH && img.Width > 0:
+			// Keep the author's width and give the height that goes with it.
+			fmt.Fprintf(&extra, ` height="%d"`, width*img.Height/img.Width)
+		}
+		if _, ok := attrs["loading"]; !ok {
+			extra.WriteString(` loading="lazy"`)
+		}
+
+		end := len(tag) - 1
+		if strings.HasSuffix(tag, "/>") {
+			end--
+		}
+		for end > 0 && tag[end-1] == ' ' {
+			end--
+		}
+		return tag[:end] + extra.String() + tag[end:]
+	})
+}
+
+// processImages publishes Images under OutDir/images. PNG and JPEG files
+// are converted to WebP and only the WebP is published, unless it comes
+// out larger than the original. Resized copies at imageWidths are
+// published beside them as WebP too, or in the original format when WebP
+// can't be encoded. Everything else is copied as it is. Published names
+// are fingerprinted, e.g. a.png becomes a.1a2b3c4d.webp. Encoded files
+// are kept in CacheDir/images by the hash of their source, so an image is
+// only ever encoded once.
+func (r *run) processImages() error {
+	r.images = imageSet{}
+	r.imageCacheUsed = map[string]bool{}
+	if r.Images == nil {
+		r.pruneImageCache()
+		return nil
+	}
+	var paths []string
+	err := fs.WalkDir(r.Images, ".", func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			paths = append(paths, p)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !webpSupported {
+		for _, p := range paths {
+			if isRaster(p) {
+				return fmt.Errorf("images/%s: converting images to WebP needs cgo; build with CGO_ENABLED=1 and a C compiler", p)
+			}
+		}
+	}
+
+	outs := make([]*publishedImage, len(paths))
+	err = r.parallel(len(paths), func(i int) error {
+		out, err := r.processImage(paths[i])
+		if err != nil {
+			return fmt.Errorf("images/%s: %w", paths[i], err)
+		}
+		outs[i] = out
+		return nil
+	})
+	for i, p := range paths {
+		if outs[i] != nil {
+			r.images["/images/"+p] = outs[i]
+		}
+	}
+	if err != nil {
+		return err
+	}
+	r.pruneImageCache()
+	return nil
+}
+
+// processImage publishes one image and its resized copies.
+func (r *run) processImage(p string) (*publishedImage, error) {
+	src, err := fs.ReadFile(r.Images, p)
+	if err != nil {
+		return nil, err
+	}
+	srcHash := hashBytes(src)
+	fp := hashBytes([]byte(srcHash + "\x00" + imageEncoder))[:8]
+	asIs := func() (*publishedImage, error) {
+		name := fingerprint(p, fp)
+		img := &publishedImage{Src: "/images/" + name, Files: []string{"/images/" + name}}
+		return img, r.publishImage(name, []any{srcHash, imageEncoder}, func() ([]byte, error) { return src, nil })
+	}
+	if !isRaster(p) {
+		return asIs()
+	}
+	ext := strings.ToLower(path.Ext(p))
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		r.log.Printf("images/%s: not processed: %v", p, err)
+		return asIs()
+	}
+	decoded := sync.OnceValues(func() (image.Image, error) {
+		img, _, err := image.Decode(bytes.NewReader(src))
+		return img, err
+	})
+
+	stem := strings.TrimSuffix(p, path.Ext(p))
+	// Resized copies are WebP whenever it can be encoded: they're made
+	// from scratch anyway, and a lossless PNG at a smaller size would
+	// still be far larger.
+	format, out, data := strings.TrimPrefix(ext, "."), p, src
+	webp, err := r.encodeImage(srcHash, 0, "webp", decoded)
+	if err != nil {
+		r.log.Printf("images/%s: not converted to WebP: %v", p, err)
+	} else {
+		format = "webp"
+		if len(webp) < len(src) {
+			out, data = stem+".webp", webp
+		}
+	}
+	out = fingerprint(out, fp)
+	if err := r.publishImage(out, []any{srcHash, imageEncoder}, func() ([]byte, error) { return data, nil }); err != nil {
+		return nil, err
+	}
+
+	img := &publishedImage{Src: "/images/" + out, Width: cfg.Width, Height: cfg.Height, Files: []string{"/images/" + out}}
+	var srcset []string
+	for _, w := range imageWidths {
+		if w >= cfg.Width {
+			break
+		}
+		name := fingerprint(fmt.Sprintf("%s-%dw.%s", stem, w, format), fp)
+		// An up-to-date output isn't encoded again, but its cached
+		// encoding is still wanted should the output go missing.
+		if _, err := r.imageCacheFile(srcHash, w, format); err != nil {
+			return nil, err
+		}
+		err := r.publishImage(name, []any{srcHash, imageEncoder, w}, func() ([]byte, error) {
+			return r.encodeImage(srcHash, w, format, decoded)
+		})
+		if err != nil {
+			return nil, err
+		}
+		srcset = append(srcset, fmt.Sprintf("/images/%s %dw", name, w))
+		img.Files = append(img.Files, "/images/"+name)
+	}
+	if len(srcset) > 0 {
+		img.Srcset = strings.Join(append(srcset, fmt.Sprintf("%s %dw", img.Src, cfg.Width)), ", ")
+	}
+	return img, nil
+}
+
+// isRaster reports whether p is a PNG or JPEG, the images converted to
+// WebP and resized.
+func isRaster(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// publishImage writes OutDir/images/name unless the previous build wrote
+// it from the same key.
+func (r *run) publishImage(name string, key any, data func() ([]byte, error)) error {
+	outPath := filepath.Join(r.OutDir, "images", filepath.FromSlash(name))
+	return r.cache.writePage(outPath, key, func(buf *bytes.Buffer) error {
+		b, err := data()
+		if err != nil {
+			return err
+		}
+		_, err = buf.Write(b)
+		return err
+	})
+}
+
+// encodeImage encodes the decoded source image as format ("webp", "png",
+// "jpg" or "jpeg"), scaled to width pixels wide unless width is 0. It
+// reuses an earlier result for the same source, width and format from
+// CacheDir when there is one.
+func (r *run) encodeImage(srcHash string, width int, format string, decoded func() (image.Image, error)) ([]byte, error) {
+	cached, err := r.imageCacheFile(srcHash, width, format)
+	if err != nil {
+		return nil, err
+	}
+	if cached != "" {
+		if b, err := os.ReadFile(cached); err == nil {
+			return b, nil
+		}
+	}
+	img, err := decoded()
+	if err != nil {
+		return nil, err
+	}
+	if width > 0 {
+		img = resize(img, width)
+	}
+	buf := new(bytes.Buffer)
+	switch format {
+	case "webp":
+		err = encodeWebP(buf, img)
+	case "png":
+		err = png.Encode(buf, img)
+	default:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cached != "" {
+		if err := os.MkdirAll(filepath.Dir(cached), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(cached, buf.Bytes(), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// imageCacheFile returns where encodeImage keeps the encoding of the
+// source with srcHash at width and format, or "" without a CacheDir, and
+// marks it as used by this build.
+func (r *run) imageCacheFile(srcHash string, width int, format string) (string, error) {
+	if r.CacheDir == "" {
+		return "", nil
+	}
+	key, err := hashJSON([]any{srcHash, imageEncoder, width, format})
+	if err != nil {
+		return "", err
+	}
+	name := key + "." + format
+	r.imageCacheMu.Lock()
+	r.imageCacheUsed[name] = true
+	r.imageCacheMu.Unlock()
+	return filepath.Join(r.CacheDir, "images", name), nil
+}
+
+// pruneImageCache removes the encodings in CacheDir/images this build
+// didn't use, such as those of edited or deleted images, the way
+// buildCache.prune removes stale outputs.
+func (r *run) pruneImageCache() {
+	if r.CacheDir == "" {
+		return
+	}
+	dir := filepath.Join(r.CacheDir, "images")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if r.imageCacheUsed[e.Name()] {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		if err := os.Remove(p); err != nil {
+			r.log.Printf("remove stale %s: %v", p, err)
+			continue
+		}
+		r.log.Printf("Removed stale %s", p)
+	}
+}
+
+// resize scales img to width pixels wide, keeping its aspect ratio.
+func resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := max(1, (b.Dy()*width+b.Dx()/2)/b.Dx())
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,303 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// LinkProblem is an internal reference in a generated page that doesn't
+// resolve to a file in the output, or to an id on the target page.
+type LinkProblem struct {
+	Page   string // output path of the page, e.g. "articles/x/index.html"
+	Ref    string // the href or src as written
+	Reason string
+}
+
+func (p LinkProblem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Page, p.Ref, p.Reason)
+}
+
+// LinkReport is the result of CheckLinks.
+type LinkReport struct {
+	Broken   []LinkProblem
+	External map[string][]string // external URL -> pages referring to it
+}
+
+// htmlPage is what CheckLinks needs from one generated page.
+type htmlPage struct {
+	refs []string
+	ids  map[string]bool
+}
+
+// CheckLinks parses every .html file and feed in public, the build
+// output, and checks that each internal href, src and srcset resolves to a
+// file there and that each #fragment names an id on its target page. In
+// feeds that covers the entry links and the HTML of entry bodies. Absolute
+// URLs under siteURL count as internal. Other absolute URLs are
+// collected, not fetched.
+func CheckLinks(public fs.FS, siteURL string) (*LinkReport, error) {
+	pages := map[string]*htmlPage{}
+	err := fs.WalkDir(public, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		var parse func([]byte) (*htmlPage, error)
+		switch {
+		case path.Ext(p) == ".html":
+			parse = func(b []byte) (*htmlPage, error) { return parseHTMLPage(b), nil }
+		case path.Base(p) == "feed.xml" || path.Base(p) == "atom.xml":
+			parse = parseXMLFeed
+		case path.Base(p) == "feed.json":
+			parse = parseJSONFeed
+		default:
+			return nil
+		}
+		b, err := fs.ReadFile(public, p)
+		if err != nil {
+			return err
+		}
+		page, err := parse(b)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		pages[p] = page
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(pages))
+	for p := range pages {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+
+	report := &LinkReport{External: map[string][]string{}}
+	for _, name := range names {
+		seen := map[string]bool{}
+		for _, ref := range pages[name].refs {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			internal, ok := internalRef(ref, siteURL)
+			if !ok {
+				if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+					report.External[ref] = append(report.External[ref], name)
+				}
+				continue
+			}
+			if reason := resolveRef(public, pages, name, internal); reason != "" {
+				report.Broken = append(report.Broken, LinkProblem{Page: name, Ref: ref, Reason: reason})
+			}
+		}
+	}
+	return report, nil
+}
+
+// parseHTMLPage collects the references and ids in an HTML document.
+func parseHTMLPage(b []byte) *htmlPage {
+	page := &htmlPage{ids: map[string]bool{}}
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return page
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tag, _ := z.TagName()
+		attrs := map[string]string{}
+		for {
+			k, v, more := z.TagAttr()
+			attrs[string(k)] = string(v)
+			if !more {
+				break
+			}
+		}
+		if id := attrs["id"]; id != "" {
+			page.ids[id] = true
+		}
+		if name := attrs["name"]; name != "" && string(tag) == "a" {
+			page.ids[name] = true
+		}
+		for _, k := range []string{"href", "src"} {
+			if v := strings.TrimSpace(attrs[k]); v != "" {
+				page.refs = append(page.refs, v)
+			}
+		}
+		if v := attrs["srcset"]; v != "" {
+			for _, cand := range strings.Split(v, ",") {
+				if f := strings.Fields(cand); len(f) > 0 {
+					page.refs = append(page.refs, f[0])
+				}
+			}
+		}
+		if string(tag) == "meta" {
+			switch attrs["property"] + attrs["name"] {
+			case "og:image", "twitter:image":
+				if v := strings.TrimSpace(attrs["content"]); v != "" {
+					page.refs = append(page.refs, v)
+				}
+			}
+		}
+	}
+}
+
+// parseXMLFeed collects the references in an RSS or Atom feed: the
+// links, as text or href, and those in the HTML of entry bodies.
+func parseXMLFeed(b []byte) (*htmlPage, error) {
+	page := &htmlPage{ids: map[string]bool{}}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	var elem string
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			elem = tok.Name.Local
+			for _, a := range tok.Attr {
+				if elem == "link" && a.Name.Local == "href" {
+					page.refs = append(page.refs, strings.TrimSpace(a.Value))
+				}
+			}
+		case xml.EndElement:
+			elem = ""
+		case xml.CharData:
+			switch elem {
+			case "link", "uri":
+				if v := strings.TrimSpace(string(tok)); v != "" {
+					page.refs = append(page.refs, v)
+				}
+			case "description", "content", "summary":
+				page.refs = append(page.refs, parseHTMLPage(tok).refs...)
+			}
+		}
+	}
+}
+
+// parseJSONFeed collects the references in a JSON Feed: its own URLs,
+// each item's url and image, and those in the item's HTML.
+func parseJSONFeed(b []byte) (*htmlPage, error) {
+	var feed struct {
+		HomePageURL string `json:"home_page_url"`
+		FeedURL     string `json:"feed_url"`
+		Items       []struct {
+			URL         string `json:"url"`
+			Image       string `json:"image"`
+			ContentHTML string `json:"content_html"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(b, &feed); err != nil {
+		return nil, err
+	}
+	page := &htmlPage{ids: map[string]bool{}}
+	for _, ref := range []string{feed.HomePageURL, feed.FeedURL} {
+		if ref != "" {
+			page.refs = append(page.refs, ref)
+		}
+	}
+	for _, it := range feed.Items {
+		for _, ref := range []string{it.URL, it.Image} {
+			if ref != "" {
+				page.refs = append(page.refs, ref)
+			}
+		}
+		page.refs = append(page.refs, parseHTMLPage([]byte(it.ContentHTML)).refs...)
+	}
+	return page, nil
+}
+
+// internalRef reports whether ref points into the site and, if so,
+// returns it as a URL with the site prefix removed.
+func internalRef(ref, siteURL string) (*url.URL, bool) {
+	if siteURL != "" && strings.HasPrefix(ref, siteURL) {
+		ref = strings.TrimPrefix(ref, siteURL)
+		if ref == "" {
+			ref = "/"
+		}
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return nil, false
+	}
+	return u, true
+}
+
+// resolveRef checks u, found on page, against the output. It returns why
+// the reference is broken, or "" if it isn't.
+func resolveRef(public fs.FS, pages map[string]*htmlPage, page string, u *url.URL) string {
+	target := page
+	if u.Path != "" {
+		p := u.Path
+		if !strings.HasPrefix(p, "/") {
+			p = path.Join("/", path.Dir(page), p)
+			if strings.HasSuffix(u.Path, "/") {
+				p += "/"
+			}
+		}
+		var ok bool
+		if target, ok = outputFile(public, p); !ok {
+			return missingReason(public, p)
+		}
+	}
+	if u.Fragment == "" || path.Ext(target) != ".html" {
+		return ""
+	}
+	if tp := pages[target]; tp != nil && !tp.ids[u.Fragment] {
+		return fmt.Sprintf("no element with id %q on %s", u.Fragment, target)
+	}
+	return ""
+}
+
+// outputFile maps a URL path to the file a static server would send.
+func outputFile(public fs.FS, p string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean(p), "/")
+	if name == "" {
+		name = "."
+	}
+	fi, err := fs.Stat(public, name)
+	if err != nil {
+		return "", false
+	}
+	if !fi.IsDir() {
+		return name, true
+	}
+	index := path.Join(name, "index.html")
+	if _, err := fs.Stat(public, index); err != nil {
+		return "", false
+	}
+	return index, true
+}
+
+// missingReason explains a missing file. A .webp whose original image was
+// published instead gets its own message: the build only publishes a WebP
+// when it's smaller, so references should go through the image function.
+func missingReason(public fs.FS, p string) string {
+	if path.Ext(p) == ".webp" {
+		stem := strings.TrimPrefix(strings.TrimSuffix(p, ".webp"), "/")
+		for _, ext := range []string{".png", ".PNG", ".jpg", ".JPG", ".jpeg", ".JPEG"} {
+			if _, err := fs.Stat(public, stem+ext); err == nil {
+				return "missing: only " + stem + ext + " was published"
+			}
+		}
+	}
+	return "missing"
+}
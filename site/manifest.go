@@ -0,0 +1,263 @@
+package site
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// manifestVersion is bumped whenever buildManifest changes meaning. A
+// manifest from another version is ignored, which rebuilds everything.
+const manifestVersion = 2
+
+// buildManifest records what the previous build wrote so that unchanged
+// outputs can be skipped on the next run.
+type buildManifest struct {
+	Version      int               `json:"version"`
+	BuilderHash  string            `json:"builder_hash"`
+	SiteHash     string            `json:"site_hash"`
+	TemplateHash string            `json:"template_hash"`
+	AssetsHash   string            `json:"assets_hash"` // hash of the published names of images and static files
+	Pages        map[string]string `json:"pages"`       // output path -> hash of the data it was rendered from
+}
+
+// buildCache decides which outputs need writing by comparing against the
+// manifest of the previous build, and collects the manifest for this one.
+// writePage may be called from several goroutines.
+type buildCache struct {
+	path   string
+	outDir string
+	full   bool
+	log    *log.Logger
+	prev   buildManifest
+
+	mu      sync.Mutex // guards next, written and skipped
+	next    buildManifest
+	written int
+	skipped int
+}
+
+func newManifest(builderHash, siteHash, templateHash string) buildManifest {
+	return buildManifest{
+		Version:      manifestVersion,
+		BuilderHash:  builderHash,
+		SiteHash:     siteHash,
+		TemplateHash: templateHash,
+		Pages:        map[string]string{},
+	}
+}
+
+// loadBuildCache reads the manifest at path. A missing or unreadable
+// manifest, one from another manifestVersion, or a change to site.env or
+// the templates, means nothing from the previous build can be reused; full
+// forces the same. So does a new build binary, since code changes can
+// alter output for identical data.
+// An empty path disables the manifest altogether.
+func loadBuildCache(path, outDir, siteHash, templateHash string, full bool, logger *log.Logger) *buildCache {
+	c := &buildCache{
+		path:   path,
+		outDir: outDir,
+		full:   full,
+		log:    logger,
+		prev:   newManifest("", "", ""),
+		next:   newManifest(builderHash(), siteHash, templateHash),
+	}
+	if path == "" {
+		c.full = true
+		return c
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.log.Printf("read build manifest: %v (rebuilding everything)", err)
+		}
+		c.full = true
+		return c
+	}
+	if err := json.Unmarshal(b, &c.prev); err != nil {
+		c.log.Printf("parse build manifest: %v (rebuilding everything)", err)
+		c.prev = newManifest("", "", "")
+		c.full = true
+		return c
+	}
+	if c.prev.Version != manifestVersion || c.prev.BuilderHash != c.next.BuilderHash || c.prev.SiteHash != siteHash || c.prev.TemplateHash != templateHash {
+		c.full = true
+	}
+	return c
+}
+
+// setAssets records the names images and static files were published
+// under. Pages refer to those, so a change means every page after this
+// call is rewritten.
+func (c *buildCache) setAssets(hash string) {
+	c.next.AssetsHash = hash
+	if c.prev.AssetsHash != hash {
+		c.full = true
+	}
+}
+
+// writePage renders outPath unless the previous build already wrote it
+// from identical data. key is hashed as JSON, so it should carry
+// everything the rendered output depends on. Compressible outputs under
+// outDir get precompressed copies beside them, which are tracked with the
+// output. Outputs elsewhere, such as the server directory, are tracked by
+// their path relative to outDir too.
+func (c *buildCache) writePage(outPath string, key any, render func(*bytes.Buffer) error) error {
+	rel := c.rel(outPath)
+	h, err := hashJSON(key)
+	if err != nil {
+		return err
+	}
+	outs := []string{rel}
+	if compressible(outPath) && !strings.HasPrefix(rel, "../") {
+		for _, suffix := range compressedSuffixes {
+			outs = append(outs, rel+suffix)
+		}
+	}
+	upToDate := !c.full
+	for _, out := range outs {
+		upToDate = upToDate && c.prev.Pages[out] == h && fileExists(filepath.Join(c.outDir, filepath.FromSlash(out)))
+	}
+	if upToDate {
+		c.mu.Lock()
+		for _, out := range outs {
+			c.next.Pages[out] = h
+		}
+		c.skipped++
+		c.mu.Unlock()
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	if err := render(buf); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	for _, out := range outs {
+		c.next.Pages[out] = h
+	}
+	c.written++
+	c.mu.Unlock()
+	if err := os.WriteFile(outPath, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if len(outs) > 1 {
+		return writeCompressed(outPath, buf.Bytes())
+	}
+	return nil
+}
+
+// prune removes outputs the previous build wrote that this build did not,
+// such as the page of a renamed or deleted article.
+func (c *buildCache) prune() {
+	var stale []string
+	for rel := range c.prev.Pages {
+		if _, ok := c.next.Pages[rel]; !ok {
+			stale = append(stale, rel)
+		}
+	}
+	sort.Strings(stale)
+	for _, rel := range stale {
+		p := filepath.Join(c.outDir, filepath.FromSlash(rel))
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			c.log.Printf("remove stale %s: %v", p, err)
+			continue
+		}
+		c.log.Printf("Removed stale %s", p)
+		// Drop directories left empty, stopping at the first non-empty one.
+		for dir := filepath.Dir(p); dir != c.outDir && dir != "."; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
+
+func (c *buildCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(c.next, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, b, 0o644)
+}
+
+func (c *buildCache) rel(p string) string {
+	rel, err := filepath.Rel(c.outDir, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hashJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(b), nil
+}
+
+// hashFS hashes the names and contents of every file in fsys. A nil FS
+// hashes as empty.
+func hashFS(fsys fs.FS) (string, error) {
+	h := sha256.New()
+	if fsys == nil {
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	// WalkDir visits entries in lexical order, so the hash is stable.
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		h.Write([]byte(path))
+		h.Write([]byte{0})
+		h.Write(b)
+		h.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// builderHash hashes the running executable. Only the content counts, as
+// go run builds into a different temporary path each time.
+func builderHash() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	b, err := os.ReadFile(exe)
+	if err != nil {
+		return ""
+	}
+	return hashBytes(b)
+}
+
+func fileExists(p string) bool { fi, err := os.Stat(p); return err == nil && fi.Mode().IsRegular() }
@@ -0,0 +1,322 @@
+package site
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RedirectsFile is the name of the redirect rules, both in the content
+// root, where they're written by hand, and in the server directory, where
+// the build adds the rules for article aliases and unhashed image URLs.
+const RedirectsFile = "redirects"
+
+// A Redirect is one rule of a redirects file. Rules are written one per
+// line,
+//
+//	/old/path      /new/path/          [status]
+//	/old/dir/*     /new/dir/:splat     [status]
+//	/removed/path  410
+//
+// where status is 301 (the default), 302 or 410, and # starts a comment.
+// A path matches with or without its trailing slash. A from path ending in
+// /* matches everything below it, which replaces :splat in the target.
+// The first rule that matches wins. A rule may match neither its own
+// target, which would loop, nor a page the build publishes.
+type Redirect struct {
+	From   string
+	To     string // empty for 410
+	Status int
+}
+
+// Redirects is a list of rules in the order they're tried.
+type Redirects []Redirect
+
+// ParseRedirects parses the rules in src, reporting problems against the
+// file name.
+func ParseRedirects(name string, src []byte) (Redirects, error) {
+	var rules Redirects
+	var errs []error
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rule, err := parseRedirect(fields)
+		if err != nil {
+			errs = append(errs, &FileError{Path: name, Line: n, Err: err})
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, &FileError{Path: name, Err: err})
+	}
+	return rules, errors.Join(errs...)
+}
+
+func parseRedirect(fields []string) (Redirect, error) {
+	rule := Redirect{From: fields[0], Status: http.StatusMovedPermanently}
+	if !strings.HasPrefix(rule.From, "/") {
+		return rule, fmt.Errorf("%q must be a path starting with /", rule.From)
+	}
+	if i := strings.Index(rule.From, "*"); i >= 0 && (i != len(rule.From)-1 || !strings.HasSuffix(rule.From, "/*")) {
+		return rule, fmt.Errorf("%q: * is only allowed as the last path segment", rule.From)
+	}
+	rest := fields[1:]
+	if len(rest) > 0 {
+		if status, err := strconv.Atoi(rest[len(rest)-1]); err == nil {
+			rule.Status = status
+			rest = rest[:len(rest)-1]
+		}
+	}
+	switch rule.Status {
+	case http.StatusMovedPermanently, http.StatusFound:
+		if len(rest) != 1 {
+			return rule, fmt.Errorf("want: from to [301|302]")
+		}
+		rule.To = rest[0]
+		if !strings.HasPrefix(rule.To, "/") && !httpURL(rule.To) {
+			return rule, fmt.Errorf("target %q must be a path or an http(s) URL", rule.To)
+		}
+		if strings.Contains(rule.To, ":splat") && !strings.HasSuffix(rule.From, "/*") {
+			return rule, fmt.Errorf("target %q uses :splat but %q has no *", rule.To, rule.From)
+		}
+		if loops(rule) {
+			return rule, fmt.Errorf("target %q matches %q again, so the redirect would loop", rule.To, rule.From)
+		}
+	case http.StatusGone:
+		if len(rest) != 0 {
+			return rule, fmt.Errorf("want: from 410")
+		}
+	default:
+		return rule, fmt.Errorf("status %d isn't one of 301, 302 or 410", rule.Status)
+	}
+	return rule, nil
+}
+
+// Match returns the first rule matching the URL path reqPath, with its
+// target filled in.
+func (rs Redirects) Match(reqPath string) (to string, status int, ok bool) {
+	p := trimSlash(reqPath)
+	for _, rule := range rs {
+		if prefix, wild := strings.CutSuffix(rule.From, "/*"); wild {
+			prefix = trimSlash(prefix)
+			if prefix == "/" {
+				prefix = ""
+			}
+			if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+				continue
+			}
+			// The splat is cleaned and loses its leading slashes, so that
+			// /blog//evil.com can't make /:splat a link to another host.
+			// It keeps the request's trailing slash.
+			splat := strings.TrimLeft(path.Clean("/"+strings.TrimPrefix(reqPath, prefix)), "/\\")
+			if splat != "" && strings.HasSuffix(reqPath, "/") {
+				splat += "/"
+			}
+			to := strings.ReplaceAll(rule.To, ":splat", splat)
+			if !httpURL(rule.To) && !localPath(to) {
+				return "", 0, false
+			}
+			return to, rule.Status, true
+		}
+		if trimSlash(rule.From) == p {
+			return rule.To, rule.Status, true
+		}
+	}
+	return "", 0, false
+}
+
+// loops reports whether following rule lands on a path rule matches
+// again. Match ignores trailing slashes, so /a /a/ is one such rule, and
+// /a/* /a/b/:splat another. Targets on other hosts are taken not to.
+func loops(rule Redirect) bool {
+	if httpURL(rule.To) {
+		return false
+	}
+	u, err := url.Parse(strings.ReplaceAll(rule.To, ":splat", "x"))
+	if err != nil {
+		return false
+	}
+	_, _, ok := Redirects{rule}.Match(u.Path)
+	return ok
+}
+
+// httpURL reports whether a rule's target is an http(s) URL.
+func httpURL(to string) bool {
+	return strings.HasPrefix(to, "http://") || strings.HasPrefix(to, "https://")
+}
+
+// localPath reports whether the expanded target to is a path on this
+// site, rather than something a browser would take to another host.
+func localPath(to string) bool {
+	if !strings.HasPrefix(to, "/") || strings.HasPrefix(to, "//") || strings.HasPrefix(to, "/\\") {
+		return false
+	}
+	u, err := url.Parse(to)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// String formats the rules the way ParseRedirects reads them.
+func (rs Redirects) String() string {
+	var b strings.Builder
+	for _, rule := range rs {
+		if rule.Status == http.StatusGone {
+			fmt.Fprintf(&b, "%s %d\n", rule.From, rule.Status)
+		} else {
+			fmt.Fprintf(&b, "%s %s %d\n", rule.From, rule.To, rule.Status)
+		}
+	}
+	return b.String()
+}
+
+// trimSlash drops a trailing slash from every path but the root.
+func trimSlash(p string) string {
+	if len(p) > 1 {
+		return strings.TrimSuffix(p, "/")
+	}
+	return p
+}
+
+// aliasPath is the URL path an article alias stands for: a bare slug is
+// an earlier slug under /articles/, anything starting with / a path.
+func aliasPath(alias string) string {
+	if strings.HasPrefix(alias, "/") {
+		return alias
+	}
+	return "/articles/" + alias + "/"
+}
+
+// loadRedirects reads the hand-written rules from the content root. The
+// file is optional.
+func (r *run) loadRedirects() {
+	b, err := fs.ReadFile(r.Content, RedirectsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		r.problems = append(r.problems, &FileError{Path: RedirectsFile, Err: err})
+		return
+	}
+	rules, err := ParseRedirects(RedirectsFile, b)
+	if err != nil {
+		r.problems = append(r.problems, err)
+	}
+	r.redirects = rules
+}
+
+// checkAliases reports aliases that aren't usable paths, that name a page
+// an article is published at, or that another article already claims.
+func (r *run) checkAliases(arts []Article, slugs map[string]slugUse) {
+	seen := map[string]*frontMatter{}
+	for _, a := range arts {
+		for i, alias := range a.Aliases {
+			line := a.src.itemLine("aliases", i)
+			p := aliasPath(alias)
+			switch {
+			case alias == "" || strings.ContainsAny(alias, " \t?#*"):
+				r.problems = append(r.problems, &FileError{Path: a.src.path, Line: line, Err: fmt.Errorf("alias %q must be a slug or a URL path", alias)})
+				continue
+			case !strings.HasPrefix(alias, "/") && strings.ContainsAny(alias, "/\\"):
+				r.problems = append(r.problems, &FileError{Path: a.src.path, Line: line, Err: fmt.Errorf("alias %q must be a single URL path segment, or a path starting with /", alias)})
+				continue
+			}
+			if slug, ok := strings.CutPrefix(trimSlash(p), "/articles/"); ok {
+				if other, taken := slugs[slug]; taken {
+					r.problems = append(r.problems, &FileError{Path: a.src.path, Line: line, Err: fmt.Errorf("alias %q is the page of %s", alias, other.src.path)})
+					continue
+				}
+			}
+			if first, ok := seen[trimSlash(p)]; ok {
+				r.problems = append(r.problems, &FileError{Path: a.src.path, Line: line, Err: fmt.Errorf("duplicate alias %q, also used by %s", alias, first.path)})
+				continue
+			}
+			seen[trimSlash(p)] = a.src
+		}
+	}
+}
+
+// writeRedirects writes the hand-written rules followed by one for each
+// alias of a published article and the images' unhashed URLs to the
+// server directory.
+func (r *run) writeRedirects() error {
+	rules := append(Redirects{}, r.redirects...)
+	for _, a := range r.arts {
+		for _, alias := range a.Aliases {
+			rules = append(rules, Redirect{From: aliasPath(alias), To: "/articles/" + a.Slug + "/", Status: http.StatusMovedPermanently})
+		}
+	}
+	rules = append(rules, r.imageRedirects()...)
+	if err := r.checkRedirectSources(rules); err != nil {
+		return err
+	}
+	outPath := filepath.Join(r.serverDir(), RedirectsFile)
+	return r.cache.writePage(outPath, rules, func(buf *bytes.Buffer) error {
+		buf.WriteString(rules.String())
+		return nil
+	})
+}
+
+// checkRedirectSources reports rules that match the URL of a page the
+// build wrote. The server applies rules before serving files, so such a
+// rule would hide the page.
+func (r *run) checkRedirectSources(rules Redirects) error {
+	var urls []string
+	for rel := range r.cache.next.Pages {
+		if strings.HasPrefix(rel, "../") || slices.Contains(compressedSuffixes, path.Ext(rel)) {
+			continue // the server directory, or a precompressed copy
+		}
+		u := "/" + rel
+		if path.Base(rel) == "index.html" {
+			u = strings.TrimSuffix(u, "index.html")
+		}
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	var errs []error
+	for _, rule := range rules {
+		for _, u := range urls {
+			if _, _, ok := (Redirects{rule}).Match(u); ok {
+				errs = append(errs, fmt.Errorf("%s would redirect %s, which the build publishes", rule.From, u))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// imageRedirects sends the URLs images had before they were fingerprinted,
+// /images/a.png and, for PNG and JPEG, the /images/a.webp the deploy
+// script used to make, to what's published now. Feed readers, webmentions
+// and other sites still link there. The target moves whenever the image
+// changes, so the redirects are 302s.
+func (r *run) imageRedirects() Redirects {
+	srcs := make([]string, 0, len(r.images))
+	for src := range r.images {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	var rules Redirects
+	for _, src := range srcs {
+		to := r.images[src].Src
+		rules = append(rules, Redirect{From: src, To: to, Status: http.StatusFound})
+		webp := strings.TrimSuffix(src, path.Ext(src)) + ".webp"
+		if _, ok := r.images[webp]; !ok && isRaster(src) {
+			rules = append(rules, Redirect{From: webp, To: to, Status: http.StatusFound})
+		}
+	}
+	return rules
+}
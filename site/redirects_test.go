@@ -0,0 +1,106 @@
+package site
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedirectsMatch(t *testing.T) {
+	rules, err := ParseRedirects("redirects", []byte(`
+/old          /new/
+/gone         410
+/blog/*       /:splat
+/docs/*       /manual/:splat  302
+/ext/*        https://example.org/:splat
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		to     string
+		status int
+		ok     bool
+	}{
+		{"/old", "/new/", http.StatusMovedPermanently, true},
+		{"/old/", "/new/", http.StatusMovedPermanently, true},
+		{"/gone/", "", http.StatusGone, true},
+		{"/blog", "/", http.StatusMovedPermanently, true},
+		{"/blog/", "/", http.StatusMovedPermanently, true},
+		{"/blog/a/b", "/a/b", http.StatusMovedPermanently, true},
+		{"/blog/a/b/", "/a/b/", http.StatusMovedPermanently, true},
+		{"/docs/x", "/manual/x", http.StatusFound, true},
+		{"/ext/x/", "https://example.org/x/", http.StatusMovedPermanently, true},
+		{"/blogger", "", 0, false},
+		{"/new/", "", 0, false},
+
+		// A splat can't turn a local target into another host.
+		{"/blog//evil.com", "/evil.com", http.StatusMovedPermanently, true},
+		{"/blog///evil.com/", "/evil.com/", http.StatusMovedPermanently, true},
+		{`/blog/\evil.com`, "/evil.com", http.StatusMovedPermanently, true},
+		{"/blog/../../evil.com", "/evil.com", http.StatusMovedPermanently, true},
+		{"/blog/https://evil.com", "/https:/evil.com", http.StatusMovedPermanently, true},
+	}
+	for _, tt := range tests {
+		to, status, ok := rules.Match(tt.path)
+		if to != tt.to || status != tt.status || ok != tt.ok {
+			t.Errorf("Match(%q) = %q, %d, %v; want %q, %d, %v", tt.path, to, status, ok, tt.to, tt.status, tt.ok)
+		}
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		to   string
+		want bool
+	}{
+		{"/", true},
+		{"/a/b/", true},
+		{"/a:b", true},
+		{"//evil.com", false},
+		{`/\evil.com`, false},
+		{"https://evil.com/", false},
+		{"evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := localPath(tt.to); got != tt.want {
+			t.Errorf("localPath(%q) = %v, want %v", tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	tests := []struct {
+		src string
+		ok  bool
+	}{
+		{"/a /b", true},
+		{"/a /b 302", true},
+		{"/a https://example.org/ 301", true},
+		{"/a/* /b/:splat", true},
+		{"/a 410", true},
+		{"# only a comment\n\n", true},
+		{"a /b", false},
+		{"/a b", false},
+		{"/a", false},
+		{"/a /b 307", false},
+		{"/a /b/:splat", false},
+		{"/a*/b /c", false},
+		{"/a /b 410", false},
+		{"/a /a", false},
+		{"/a /a/", false},
+		{"/a/ /a?x=1 302", false},
+		{"/a/* /a/:splat", false},
+		{"/a/* /a/b/:splat", false},
+		{"/* /new/:splat", false},
+		{"/a/* /ab/:splat", true},
+		{"/a /a/b", true},
+		{"/a https://example.org/a", true},
+	}
+	for _, tt := range tests {
+		_, err := ParseRedirects("redirects", []byte(tt.src))
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseRedirects(%q) error = %v, want ok %v", tt.src, err, tt.ok)
+		}
+	}
+}
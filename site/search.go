@@ -0,0 +1,154 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchIndex is an inverted index over the body text of every published
+// article and note. The search page loads it in the browser, so the
+// tokenizer rules there must match Tokenize.
+type SearchIndex struct {
+	Stopwords []string           `json:"stopwords"`
+	Docs      []SearchDoc        `json:"docs"`
+	Terms     map[string][][]int `json:"terms"` // term -> [doc index, term frequency] pairs
+}
+
+// SearchDoc describes one indexed article or note.
+type SearchDoc struct {
+	SearchResult
+	Len int `json:"len"` // number of indexed terms in the body, for ranking
+}
+
+// SearchResult is what a search shows of a document.
+type SearchResult struct {
+	Title string   `json:"title"`
+	URL   string   `json:"url"`
+	Type  string   `json:"type"` // "article" or "note"
+	Date  string   `json:"date"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// Stopwords are left out of the index and of queries.
+var Stopwords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in",
+	"into", "is", "it", "no", "not", "of", "on", "or", "so", "that", "the",
+	"their", "then", "there", "these", "they", "this", "to", "was", "will", "with",
+}
+
+var stopwordSet = func() map[string]bool {
+	m := map[string]bool{}
+	for _, w := range Stopwords {
+		m[w] = true
+	}
+	return m
+}()
+
+// Tokenize lowercases s and splits it on anything that isn't a letter or
+// digit, dropping single characters and stopwords.
+func Tokenize(s string) []string {
+	var out []string
+	for _, f := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len([]rune(f)) < 2 || stopwordSet[f] {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func (r *run) writeSearchIndex(outPath string) error {
+	idx := SearchIndex{Stopwords: Stopwords, Terms: map[string][][]int{}}
+	add := func(doc SearchDoc, contentHTML string) {
+		terms := Tokenize(html.UnescapeString(plainText(contentHTML)))
+		doc.Len = len(terms)
+		tf := map[string]int{}
+		for _, t := range terms {
+			tf[t]++
+		}
+		keys := make([]string, 0, len(tf))
+		for t := range tf {
+			keys = append(keys, t)
+		}
+		sort.Strings(keys)
+		i := len(idx.Docs)
+		for _, t := range keys {
+			idx.Terms[t] = append(idx.Terms[t], []int{i, tf[t]})
+		}
+		idx.Docs = append(idx.Docs, doc)
+	}
+	for _, a := range r.arts {
+		add(SearchDoc{SearchResult: SearchResult{
+			Title: a.Title,
+			URL:   "/articles/" + a.Slug + "/",
+			Type:  "article",
+			Date:  a.Date,
+			Tags:  tagNames(a.Tags),
+		}}, a.ContentHTML)
+	}
+	for _, n := range r.notes {
+		add(SearchDoc{SearchResult: SearchResult{
+			Title: n.Title,
+			URL:   "/notes/" + n.Slug + "/",
+			Type:  "note",
+			Date:  n.Date,
+			Tags:  tagNames(n.Tags),
+		}}, n.ContentHTML)
+	}
+	return r.cache.writePage(outPath, idx, func(buf *bytes.Buffer) error {
+		return json.NewEncoder(buf).Encode(idx)
+	})
+}
+
+// ServerConfigFile and ServerTemplatesDir are the site settings and the
+// page templates as copied to the server directory, so the server can
+// render search results through list.html.tmpl without the source tree.
+const (
+	ServerConfigFile   = "site.env"
+	ServerTemplatesDir = "templates"
+)
+
+// writeSearchTemplates copies site.env and the top-level templates, which
+// include list.html.tmpl and its partials, to the server directory.
+func (r *run) writeSearchTemplates() error {
+	cfg := r.Config.String()
+	if err := r.cache.writePage(filepath.Join(r.serverDir(), ServerConfigFile), cfg, func(buf *bytes.Buffer) error {
+		buf.WriteString(cfg)
+		return nil
+	}); err != nil {
+		return err
+	}
+	names, err := fs.Glob(r.Templates, "*.html.tmpl")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		b, err := fs.ReadFile(r.Templates, name)
+		if err != nil {
+			return err
+		}
+		if err := r.cache.writePage(filepath.Join(r.serverDir(), ServerTemplatesDir, name), string(b), func(buf *bytes.Buffer) error {
+			buf.Write(b)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tagNames(tags []Tag) []string {
+	var names []string
+	for _, tg := range tags {
+		names = append(names, tg.Name)
+	}
+	return names
+}
@@ -0,0 +1,29 @@
+package site
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"the cat and a hat", []string{"cat", "hat"}},
+		{"x y z go", []string{"go"}},
+		{"don't-stop", []string{"don", "stop"}},
+		{"Go 1.25 release", []string{"go", "25", "release"}},
+		{"Café naïve Ελλάδα", []string{"café", "naïve", "ελλάδα"}},
+		{"é ü", nil},
+		{"go go Go", []string{"go", "go", "go"}},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.in); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
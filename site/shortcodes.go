@@ -0,0 +1,216 @@
+package site
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Shortcodes let Markdown content use themed snippets instead of raw HTML.
+// A shortcode is written
+//
+//	{{< name key="value" >}}
+//
+// or, when it wraps content,
+//
+//	{{< name key="value" >}}
+//	Markdown, which may contain more shortcodes
+//	{{< /name >}}
+//
+// and is rendered by templates/shortcodes/name.html.tmpl with its
+// arguments in .Args and the rendered inner Markdown in .Inner. Shortcodes
+// inside fenced code are left alone, and {{</* name */>}} is written out
+// as {{< name >}}.
+
+// shortcode is the data a shortcode template is executed with.
+type shortcode struct {
+	Name  string
+	Args  map[string]string
+	Inner template.HTML
+}
+
+var (
+	reShortcode        = regexp.MustCompile(`\{\{<\s*(/?)([A-Za-z][\w-]*)((?:[^>"']|"(?:[^"\\]|\\.)*"|'[^']*')*?)\s*>\}\}`)
+	reShortcodeArg     = regexp.MustCompile(`([A-Za-z][\w-]*)=(?:"((?:[^"\\]|\\.)*)"|'([^']*)'|(\S+))`)
+	reShortcodeEscaped = regexp.MustCompile(`\{\{</\*(.*?)\*/>\}\}`)
+)
+
+// parseShortcodes parses every template in the shortcodes directory of
+// fsys, keyed by shortcode name. The directory is optional.
+func parseShortcodes(fsys fs.FS) (map[string]*template.Template, error) {
+	names, err := fs.Glob(fsys, "shortcodes/*.html.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	tpls := map[string]*template.Template{}
+	var errs []error
+	for _, name := range names {
+		tpl, err := ParseTemplate(fsys, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		tpls[strings.TrimSuffix(path.Base(name), ".html.tmpl")] = tpl
+	}
+	return tpls, errors.Join(errs...)
+}
+
+// shortcodeTag is one {{< >}} tag found in Markdown source.
+type shortcodeTag struct {
+	start, end int // byte offsets of the whole tag
+	closing    bool
+	name       string
+	args       string
+}
+
+// renderMarkdown converts a Markdown body, expanding its shortcodes.
+// Shortcodes are swapped for plain-text placeholders before conversion and
+// their output put in place afterwards, so goldmark never sees it. line is
+// the file line src starts on, for errors.
+func (r *run) renderMarkdown(path string, src []byte, line int) (string, []error) {
+	tags := findShortcodes(src)
+	if len(tags) == 0 {
+		out, err := convertMarkdown(unescapeShortcodes(src))
+		if err != nil {
+			return "", []error{&FileError{Path: path, Err: err}}
+		}
+		return out, nil
+	}
+
+	lineAt := func(off int) int { return line + bytes.Count(src[:off], []byte("\n")) }
+	nonce := hashBytes(src)[:12]
+	var (
+		md    bytes.Buffer
+		outs  []string
+		errs  []error
+		start int
+	)
+	for i := 0; i < len(tags); i++ {
+		t := tags[i]
+		md.Write(src[start:t.start])
+		start = t.end
+		if t.closing {
+			errs = append(errs, &FileError{Path: path, Line: lineAt(t.start), Err: fmt.Errorf("{{< /%s >}} without an opening tag", t.name)})
+			continue
+		}
+
+		// A tag wraps content if a matching closing tag follows it, with
+		// any nested tags of the same name balanced.
+		var inner template.HTML
+		depth := 0
+		for j := i + 1; j < len(tags); j++ {
+			if tags[j].name != t.name {
+				continue
+			}
+			if !tags[j].closing {
+				depth++
+				continue
+			}
+			if depth > 0 {
+				depth--
+				continue
+			}
+			html, innerErrs := r.renderMarkdown(path, src[t.end:tags[j].start], lineAt(t.end))
+			errs = append(errs, innerErrs...)
+			inner = template.HTML(html)
+			start, i = tags[j].end, j
+			break
+		}
+
+		html, err := r.executeShortcode(t, inner)
+		if err != nil {
+			errs = append(errs, &FileError{Path: path, Line: lineAt(t.start), Err: err})
+			continue
+		}
+		// A shortcode on lines of its own stands as a block, even without
+		// blank lines around it; otherwise it's inline.
+		ph := fmt.Sprintf("shortcode-%s-%d-", nonce, len(outs))
+		if (t.start == 0 || src[t.start-1] == '\n') && (start == len(src) || src[start] == '\n' || src[start] == '\r') {
+			ph = "\n\n" + ph + "\n\n"
+		}
+		md.WriteString(ph)
+		outs = append(outs, html)
+	}
+	md.Write(src[start:])
+
+	out, err := convertMarkdown(unescapeShortcodes(md.Bytes()))
+	if err != nil {
+		return "", append(errs, &FileError{Path: path, Err: err})
+	}
+	for i, html := range outs {
+		ph := fmt.Sprintf("shortcode-%s-%d-", nonce, i)
+		out = strings.Replace(out, "<p>"+ph+"</p>", html, 1)
+		out = strings.Replace(out, ph, html, 1)
+	}
+	return out, errs
+}
+
+// executeShortcode renders one shortcode with its template.
+func (r *run) executeShortcode(t shortcodeTag, inner template.HTML) (string, error) {
+	tpl, ok := r.shortcodes[t.name]
+	if !ok {
+		return "", fmt.Errorf("unknown shortcode %q (no templates/shortcodes/%s.html.tmpl)", t.name, t.name)
+	}
+	sc := shortcode{Name: t.name, Args: map[string]string{}, Inner: inner}
+	rest := strings.TrimSpace(reShortcodeArg.ReplaceAllStringFunc(t.args, func(m string) string {
+		sm := reShortcodeArg.FindStringSubmatch(m)
+		v := sm[2] + sm[3] + sm[4]
+		if sm[2] != "" {
+			if u, err := strconv.Unquote(`"` + sm[2] + `"`); err == nil {
+				v = u
+			}
+		}
+		sc.Args[sm[1]] = v
+		return ""
+	}))
+	if rest != "" {
+		return "", fmt.Errorf("shortcode %s: can't parse arguments %q; use key=\"value\"", t.name, rest)
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, sc); err != nil {
+		return "", fmt.Errorf("shortcode %s: %w", t.name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// findShortcodes returns the shortcode tags in src outside fenced code
+// blocks, in order.
+func findShortcodes(src []byte) []shortcodeTag {
+	var tags []shortcodeTag
+	fence := ""
+	off := 0
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		trimmed := strings.TrimSpace(string(line))
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:3]
+		default:
+			for _, m := range reShortcode.FindAllSubmatchIndex(line, -1) {
+				tags = append(tags, shortcodeTag{
+					start:   off + m[0],
+					end:     off + m[1],
+					closing: m[3] > m[2],
+					name:    string(line[m[4]:m[5]]),
+					args:    string(line[m[6]:m[7]]),
+				})
+			}
+		}
+		off += len(line)
+	}
+	return tags
+}
+
+// unescapeShortcodes turns each {{</* x */>}} into a literal {{< x >}}.
+func unescapeShortcodes(src []byte) []byte {
+	return reShortcodeEscaped.ReplaceAll(src, []byte("{{<$1>}}"))
+}
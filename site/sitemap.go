@@ -0,0 +1,50 @@
+package site
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type sitemapURL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name          `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURLEntry `xml:"url"`
+}
+
+type sitemapURLEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func (r *run) writeSitemap(outPath string, urls []sitemapURL) error {
+	sort.Slice(urls, func(i, j int) bool { return urls[i].Loc < urls[j].Loc })
+	set := sitemapURLSet{}
+	for _, u := range urls {
+		e := sitemapURLEntry{Loc: u.Loc}
+		if !u.LastMod.IsZero() {
+			e.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, e)
+	}
+	return r.cache.writePage(outPath, set, func(buf *bytes.Buffer) error {
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(buf)
+		enc.Indent("", "  ")
+		return enc.Encode(set)
+	})
+}
+
+func (r *run) writeRobots(outPath string) error {
+	site := r.Config
+	return r.cache.writePage(outPath, site.URL, func(buf *bytes.Buffer) error {
+		fmt.Fprintf(buf, "User-agent: *\nDisallow: /drafts/\n\nSitemap: %s/sitemap.xml\n", site.URL)
+		return nil
+	})
+}
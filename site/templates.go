@@ -0,0 +1,69 @@
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// templateFuncs are available to every page template.
+var templateFuncs = template.FuncMap{
+	"split": strings.Split,
+	"isURL": func(s *string) bool {
+		if s == nil {
+			return false
+		}
+		return strings.HasPrefix(*s, "http://") || strings.HasPrefix(*s, "https://")
+	},
+	"deref": deref,
+	// image maps a source image URL to the one published for it; a build
+	// replaces it once the images are processed.
+	"image": func(src string) string { return src },
+	// asset does the same for static files such as stylesheets.
+	"asset": func(src string) string { return src },
+	// required returns args[key] and fails the template if it's empty, so
+	// a shortcode missing an argument is reported where it's used.
+	"required": func(args map[string]string, key string) (string, error) {
+		if args[key] == "" {
+			return "", fmt.Errorf("missing argument %s", key)
+		}
+		return args[key], nil
+	},
+}
+
+// ParseTemplate parses the page template name from fsys together with
+// every partial beside it, i.e. each *.html.tmpl file that starts with a
+// {{define}} block.
+func ParseTemplate(fsys fs.FS, name string) (*template.Template, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("read template %s: %w", name, err)
+	}
+	tpl, err := template.New(path.Base(name)).Funcs(templateFuncs).Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("parse template %s: %w", name, err)
+	}
+	partials, err := fs.Glob(fsys, path.Join(path.Dir(name), "*.html.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range partials {
+		if p == name {
+			continue
+		}
+		pb, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("read partial %s: %w", p, err)
+		}
+		if !bytes.HasPrefix(bytes.TrimSpace(pb), []byte("{{define")) {
+			continue
+		}
+		if _, err := tpl.Parse(string(pb)); err != nil {
+			return nil, fmt.Errorf("parse partial %s: %w", p, err)
+		}
+	}
+	return tpl, nil
+}
@@ -0,0 +1,75 @@
+package site
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// headingAnchors gives every heading with an id a permalink to itself:
+// an empty <a class="anchor"> after its text, which the stylesheet shows
+// as a "#" on hover. It's empty so the anchor adds nothing to the search
+// index or reading time.
+type headingAnchors struct{}
+
+func (headingAnchors) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		id, ok := h.AttributeString("id")
+		if b, isBytes := id.([]byte); ok && isBytes && len(b) > 0 {
+			link := ast.NewLink()
+			link.Destination = append([]byte("#"), b...)
+			link.SetAttributeString("class", []byte("anchor"))
+			link.SetAttributeString("title", []byte("Permalink to this section"))
+			h.AppendChild(h, link)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// reTOCHeading matches the h2 and h3 elements with an id in rendered HTML.
+var reTOCHeading = regexp.MustCompile(`(?s)<h([23])\s[^>]*\bid="([^"]+)"[^>]*>(.*?)</h[23]>`)
+
+// tableOfContents lists the h2 and h3 headings in contentHTML as nested
+// links, or returns "" when there are fewer than two.
+func tableOfContents(contentHTML string) template.HTML {
+	matches := reTOCHeading.FindAllStringSubmatch(contentHTML, -1)
+	if len(matches) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<ul>")
+	depth := 0 // 1 while inside an h3 list
+	for i, m := range matches {
+		level := 0
+		if m[1] == "3" && i > 0 {
+			level = 1
+		}
+		switch {
+		case i == 0:
+		case level > depth:
+			b.WriteString("<ul>")
+		case level < depth:
+			b.WriteString("</li></ul></li>")
+		default:
+			b.WriteString("</li>")
+		}
+		depth = level
+		label := strings.TrimSpace(html.UnescapeString(reTags.ReplaceAllString(m[3], "")))
+		b.WriteString(`<li><a href="#` + m[2] + `">` + template.HTMLEscapeString(label) + "</a>")
+	}
+	b.WriteString("</li>")
+	if depth > 0 {
+		b.WriteString("</ul></li>")
+	}
+	b.WriteString("</ul>")
+	return template.HTML(b.String())
+}
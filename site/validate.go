@@ -0,0 +1,203 @@
+package site
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// validate checks each loaded article and note on its own and then
+// against each other: slugs must be unique, every use of a tag slug must
+// carry the same name, and hero images must exist. Drafts being built and
+// scheduled items are checked too, whether or not they're rendered, so
+// problems surface before they go live. Images nobody refers to are
+// logged but aren't problems.
+func (r *run) validate() {
+	type article struct {
+		Article
+		published bool
+	}
+	type note struct {
+		Note
+		published bool
+	}
+	var arts []article
+	for _, a := range r.arts {
+		arts = append(arts, article{a, true})
+	}
+	for _, a := range append(append([]Article{}, r.draftArts...), r.heldArts...) {
+		arts = append(arts, article{a, false})
+	}
+	var notes []note
+	for _, n := range r.notes {
+		notes = append(notes, note{n, true})
+	}
+	for _, n := range append(append([]Note{}, r.draftNotes...), r.heldNotes...) {
+		notes = append(notes, note{n, false})
+	}
+	sort.Slice(arts, func(i, j int) bool { return arts[i].src.path < arts[j].src.path })
+	sort.Slice(notes, func(i, j int) bool { return notes[i].src.path < notes[j].src.path })
+
+	articleSlugs := map[string]slugUse{}
+	noteSlugs := map[string]slugUse{}
+	tags := tagUses{}
+	all := make([]Article, 0, len(arts))
+	for _, a := range arts {
+		r.checkCommon(a.src, a.Slug, a.Title, a.Tags)
+		r.checkSlug(articleSlugs, a.Slug, slugUse{a.src, a.t, a.published})
+		tags.check(r, a.src, a.Tags)
+		if a.Updated != nil {
+			if _, err := parseDate(*a.Updated); err != nil {
+				r.problems = append(r.problems, a.src.errorf("updated", "%v", err))
+			}
+		}
+		if a.Hero != nil {
+			r.checkHero(a.src, a.Hero)
+		}
+		all = append(all, a.Article)
+	}
+	r.checkAliases(all, articleSlugs)
+	for _, n := range notes {
+		r.checkCommon(n.src, n.Slug, n.Title, n.Tags)
+		r.checkSlug(noteSlugs, n.Slug, slugUse{n.src, n.t, n.published})
+		tags.check(r, n.src, n.Tags)
+	}
+
+	r.reportUnusedImages()
+}
+
+// checkCommon checks the fields articles and notes share.
+func (r *run) checkCommon(src *frontMatter, slug, title string, tags []Tag) {
+	if strings.TrimSpace(title) == "" {
+		r.problems = append(r.problems, src.errorf("title", "title is required"))
+	}
+	switch {
+	case slug == "":
+		r.problems = append(r.problems, src.errorf("slug", "slug is required"))
+	case slug == "." || slug == ".." || strings.ContainsAny(slug, "/\\ \t?#"):
+		r.problems = append(r.problems, src.errorf("slug", "slug %q must be a single URL path segment", slug))
+	}
+	for i, tg := range tags {
+		if tg.Name == "" || tg.Slug == "" {
+			r.problems = append(r.problems, &FileError{Path: src.path, Line: src.itemLine("tags", i), Err: fmt.Errorf("tag needs both a name and a slug")})
+		}
+	}
+}
+
+// slugUse is a file claiming a slug.
+type slugUse struct {
+	src       *frontMatter
+	t         time.Time
+	published bool
+}
+
+// checkSlug reports slug if another file of the same kind already uses it,
+// since both would render to the same page. The problem is reported
+// against the file that's unpublished, or else newer, as that's the one
+// most likely to need a new slug, and names the other.
+func (r *run) checkSlug(seen map[string]slugUse, slug string, use slugUse) {
+	if slug == "" {
+		return
+	}
+	first, ok := seen[slug]
+	if !ok {
+		seen[slug] = use
+		return
+	}
+	blameFirst := !first.published && use.published
+	if first.published == use.published {
+		blameFirst = first.t.After(use.t)
+	}
+	blame, other := use, first
+	if blameFirst {
+		blame, other = first, use
+		seen[slug] = use
+	}
+	r.problems = append(r.problems, blame.src.errorf("slug", "duplicate slug %q, also used by %s:%d", slug, other.src.path, other.src.line("slug")))
+}
+
+// tagUses remembers where each tag slug was first named.
+type tagUses map[string]struct {
+	name string
+	src  *frontMatter
+	line int
+}
+
+func (t tagUses) check(r *run, src *frontMatter, tags []Tag) {
+	for i, tg := range tags {
+		if tg.Slug == "" {
+			continue
+		}
+		line := src.itemLine("tags", i)
+		first, ok := t[tg.Slug]
+		if !ok {
+			first.name, first.src, first.line = tg.Name, src, line
+			t[tg.Slug] = first
+			continue
+		}
+		if first.name != tg.Name {
+			r.problems = append(r.problems, &FileError{Path: src.path, Line: line, Err: fmt.Errorf("tag %q is named %q here but %q in %s:%d", tg.Slug, tg.Name, first.name, first.src.path, first.line)})
+		}
+	}
+}
+
+// checkHero reports a hero image that isn't among the static images.
+// External images aren't checked.
+func (r *run) checkHero(src *frontMatter, h *Hero) {
+	if h.Src == "" {
+		r.problems = append(r.problems, src.errorf("hero", "hero needs a src"))
+		return
+	}
+	if r.Images == nil || !strings.HasPrefix(h.Src, "/images/") {
+		return
+	}
+	if _, err := fs.Stat(r.Images, strings.TrimPrefix(h.Src, "/images/")); err != nil {
+		r.problems = append(r.problems, src.errorf("hero", "hero image %s not found", h.Src))
+	}
+}
+
+// reportUnusedImages logs every file in the images directory that no
+// content file (drafts included), template, stylesheet or site setting
+// refers to. A reference to the .webp version of an image counts for the
+// original.
+func (r *run) reportUnusedImages() {
+	images := r.Images
+	if images == nil {
+		return
+	}
+	var refs strings.Builder
+	refs.WriteString(r.Config.AuthorPhoto + "\n" + r.Config.DefaultOGImage + "\n")
+	for _, src := range []struct {
+		fsys fs.FS
+		root string
+	}{{r.Content, "articles"}, {r.Content, "notes"}, {r.Templates, "."}, {r.Static["css"], "."}} {
+		if src.fsys == nil {
+			continue
+		}
+		_ = fs.WalkDir(src.fsys, src.root, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if b, err := fs.ReadFile(src.fsys, p); err == nil {
+					refs.Write(b)
+				}
+			}
+			return nil
+		})
+	}
+	used := refs.String()
+
+	_ = fs.WalkDir(images, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		url := "/images/" + p
+		stem := strings.TrimSuffix(url, path.Ext(url))
+		if strings.Contains(used, url) || strings.Contains(used, stem+".webp") {
+			return nil
+		}
+		r.log.Printf("Unused image: images/%s", p)
+		return nil
+	})
+}
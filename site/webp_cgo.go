@@ -0,0 +1,21 @@
+//go:build cgo
+
+package site
+
+import (
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+// webpEncoder names the WebP encoder in image cache keys.
+const webpEncoder = "libwebp-chai2010-1.4.0"
+
+// webpSupported reports whether encodeWebP works.
+const webpSupported = true
+
+// encodeWebP writes img as lossy WebP at webpQuality, as cwebp -q does.
+func encodeWebP(w io.Writer, img image.Image) error {
+	return webp.Encode(w, img, &webp.Options{Quality: webpQuality})
+}
@@ -0,0 +1,22 @@
+//go:build !cgo
+
+package site
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// webpEncoder names the WebP encoder in image cache keys.
+const webpEncoder = "none"
+
+// webpSupported reports whether encodeWebP works. libwebp needs cgo, so
+// processImages refuses to publish PNG and JPEG images without it rather
+// than let public/ depend on how the builder was compiled. cmd/serve is
+// built this way but never encodes images.
+const webpSupported = false
+
+func encodeWebP(w io.Writer, img image.Image) error {
+	return errors.New("WebP encoding needs a build with cgo")
+}